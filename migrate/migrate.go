@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/ssoroka/gomigrate/migrator"
 )
@@ -13,6 +14,12 @@ var (
 	newMigrationFlagSet  = flag.NewFlagSet("new", flag.PanicOnError)
 	upMigrationFlagSet   = flag.NewFlagSet("up", flag.PanicOnError)
 	downMigrationFlagSet = flag.NewFlagSet("down", flag.PanicOnError)
+	redoMigrationFlagSet = flag.NewFlagSet("redo", flag.PanicOnError)
+	statusFlagSet        = flag.NewFlagSet("status", flag.PanicOnError)
+	unlockFlagSet        = flag.NewFlagSet("unlock", flag.PanicOnError)
+	historyFlagSet       = flag.NewFlagSet("history", flag.PanicOnError)
+	verifyFlagSet        = flag.NewFlagSet("verify", flag.PanicOnError)
+	baselineFlagSet      = flag.NewFlagSet("baseline", flag.PanicOnError)
 
 	options = &migrator.Options{
 		Install: migrator.InstallOptions{
@@ -24,20 +31,92 @@ var (
 		},
 		Build: migrator.BuildOptions{},
 		Up: migrator.UpDownOptions{
-			PreDeployOnly:  upMigrationFlagSet.Bool("pre", false, "Run Pre-deploy scripts only (default is all)"),
-			PostDeployOnly: upMigrationFlagSet.Bool("post", false, "Run Post-deploy scripts only (default is all)"),
-			Version:        upMigrationFlagSet.String("version", "", "Run up only on this version"),
-			Force:          upMigrationFlagSet.Bool("force", false, "Force the migration to run, even if it has already run successfully"),
-			Production:     upMigrationFlagSet.Bool("production", false, "set this to true for production so that any supplied migrator binary is not rebuilt"),
-			Help:           upMigrationFlagSet.Bool("help", false, "Help"),
+			PreDeployOnly:         upMigrationFlagSet.Bool("pre", false, "Run Pre-deploy scripts only (default is all)"),
+			PostDeployOnly:        upMigrationFlagSet.Bool("post", false, "Run Post-deploy scripts only (default is all)"),
+			Version:               upMigrationFlagSet.String("version", "", "Run up only on this version"),
+			ToVersion:             upMigrationFlagSet.String("to", "", "Run all pending migrations up to and including this version"),
+			Force:                 upMigrationFlagSet.Bool("force", false, "Force the migration to run, even if it has already run successfully"),
+			DryRun:                upMigrationFlagSet.Bool("dry-run", false, "Print the steps that would run without running them or changing the database"),
+			ResetCheckpoints:      upMigrationFlagSet.Bool("reset-checkpoints", false, "With -force, start the steps over instead of resuming them from their checkpoints"),
+			Parallel:              upMigrationFlagSet.Int("parallel", 0, "With -post, run the post-deploy steps of up to N migrations marked Parallelizable at the same time"),
+			Output:                upMigrationFlagSet.String("output", "text", "Output format, text or json. json prints a JSON object per line for every step and a final summary"),
+			OutOfOrderPolicy:      upMigrationFlagSet.String("out-of-order", "", "What to do when a pending migration is older than the newest applied one: error, warn or allow"),
+			UnknownVersionsPolicy: upMigrationFlagSet.String("unknown-versions", "", "What to do when applied versions aren't registered: error, warn or allow"),
+			ChecksumPolicy:        upMigrationFlagSet.String("checksum-policy", "", "What to do when an applied migration has been modified since: error, warn or allow"),
+			LockTimeout:           upMigrationFlagSet.Duration("lock-timeout", time.Minute, "How long to wait for another migrator to release the migration lock"),
+			RetryAttempts:         upMigrationFlagSet.Int("retry-attempts", 0, "How many times to attempt a step that fails with a retryable error, for migrations without their own Retry"),
+			RetryBackoff:          upMigrationFlagSet.Duration("retry-backoff", time.Second, "How long to wait before retrying a step, doubled for each retry after the first"),
+			Production:            upMigrationFlagSet.Bool("production", false, "set this to true for production so that any supplied migrator binary is not rebuilt"),
+			Help:                  upMigrationFlagSet.Bool("help", false, "Help"),
 		},
 		Down: migrator.UpDownOptions{
-			PreDeployOnly:  downMigrationFlagSet.Bool("pre", false, "Run Pre-deploy scripts only (default is all)"),
-			PostDeployOnly: downMigrationFlagSet.Bool("post", false, "Run Post-deploy scripts only (default is all)"),
-			Version:        downMigrationFlagSet.String("version", "", "Run down only on this version"),
-			Force:          downMigrationFlagSet.Bool("force", false, "Force the migration to run, even if it has not run, or already run down successfully"),
-			Production:     downMigrationFlagSet.Bool("production", false, "set this to true for production so that any supplied migrator binary is not rebuilt"),
-			Help:           downMigrationFlagSet.Bool("help", false, "Help"),
+			PreDeployOnly:         downMigrationFlagSet.Bool("pre", false, "Run Pre-deploy scripts only (default is all)"),
+			PostDeployOnly:        downMigrationFlagSet.Bool("post", false, "Run Post-deploy scripts only (default is all)"),
+			Version:               downMigrationFlagSet.String("version", "", "Run down only on this version"),
+			Steps:                 downMigrationFlagSet.Int("steps", 0, "Run down the last N applied migrations, newest first"),
+			ToVersion:             downMigrationFlagSet.String("to", "", "Run down every applied migration newer than this version, newest first"),
+			Force:                 downMigrationFlagSet.Bool("force", false, "Force the migration to run, even if it has not run, or already run down successfully"),
+			DryRun:                downMigrationFlagSet.Bool("dry-run", false, "Print the steps that would run without running them or changing the database"),
+			ResetCheckpoints:      downMigrationFlagSet.Bool("reset-checkpoints", false, "With -force, start the steps over instead of resuming them from their checkpoints"),
+			Output:                downMigrationFlagSet.String("output", "text", "Output format, text or json. json prints a JSON object per line for every step and a final summary"),
+			OutOfOrderPolicy:      downMigrationFlagSet.String("out-of-order", "", "What to do when a pending migration is older than the newest applied one: error, warn or allow"),
+			UnknownVersionsPolicy: downMigrationFlagSet.String("unknown-versions", "", "What to do when applied versions aren't registered: error, warn or allow"),
+			ChecksumPolicy:        downMigrationFlagSet.String("checksum-policy", "", "What to do when an applied migration has been modified since: error, warn or allow"),
+			MissingDownPolicy:     downMigrationFlagSet.String("missing-down", "", "What to do when running down a step without a down function: error, warn or allow"),
+			LockTimeout:           downMigrationFlagSet.Duration("lock-timeout", time.Minute, "How long to wait for another migrator to release the migration lock"),
+			RetryAttempts:         downMigrationFlagSet.Int("retry-attempts", 0, "How many times to attempt a step that fails with a retryable error, for migrations without their own Retry"),
+			RetryBackoff:          downMigrationFlagSet.Duration("retry-backoff", time.Second, "How long to wait before retrying a step, doubled for each retry after the first"),
+			Production:            downMigrationFlagSet.Bool("production", false, "set this to true for production so that any supplied migrator binary is not rebuilt"),
+			Help:                  downMigrationFlagSet.Bool("help", false, "Help"),
+		},
+		Redo: migrator.UpDownOptions{
+			PreDeployOnly:         redoMigrationFlagSet.Bool("pre", false, "Redo Pre-deploy scripts only (default is all)"),
+			PostDeployOnly:        redoMigrationFlagSet.Bool("post", false, "Redo Post-deploy scripts only (default is all)"),
+			Version:               redoMigrationFlagSet.String("version", "", "Redo this version (default is the latest applied migration)"),
+			Force:                 redoMigrationFlagSet.Bool("force", false, "Run the migration down even if it is irreversible"),
+			DryRun:                redoMigrationFlagSet.Bool("dry-run", false, "Print the steps that would run without running them or changing the database"),
+			ResetCheckpoints:      redoMigrationFlagSet.Bool("reset-checkpoints", false, "With -force, start the steps over instead of resuming them from their checkpoints"),
+			Output:                redoMigrationFlagSet.String("output", "text", "Output format, text or json. json prints a JSON object per line for every step and a final summary"),
+			OutOfOrderPolicy:      redoMigrationFlagSet.String("out-of-order", "", "What to do when a pending migration is older than the newest applied one: error, warn or allow"),
+			UnknownVersionsPolicy: redoMigrationFlagSet.String("unknown-versions", "", "What to do when applied versions aren't registered: error, warn or allow"),
+			ChecksumPolicy:        redoMigrationFlagSet.String("checksum-policy", "", "What to do when an applied migration has been modified since: error, warn or allow"),
+			MissingDownPolicy:     redoMigrationFlagSet.String("missing-down", "", "What to do when running down a step without a down function: error, warn or allow"),
+			LockTimeout:           redoMigrationFlagSet.Duration("lock-timeout", time.Minute, "How long to wait for another migrator to release the migration lock"),
+			RetryAttempts:         redoMigrationFlagSet.Int("retry-attempts", 0, "How many times to attempt a step that fails with a retryable error, for migrations without their own Retry"),
+			RetryBackoff:          redoMigrationFlagSet.Duration("retry-backoff", time.Second, "How long to wait before retrying a step, doubled for each retry after the first"),
+			Production:            redoMigrationFlagSet.Bool("production", false, "set this to true for production so that any supplied migrator binary is not rebuilt"),
+			Help:                  redoMigrationFlagSet.Bool("help", false, "Help"),
+		},
+		Status: migrator.StatusOptions{
+			UnknownVersionsPolicy: statusFlagSet.String("unknown-versions", "", "What to do when applied versions aren't registered: error, warn or allow"),
+			ChecksumPolicy:        statusFlagSet.String("checksum-policy", "", "What to do when an applied migration has been modified since: error, warn or allow"),
+			Production:            statusFlagSet.Bool("production", false, "set this to true for production so that any supplied migrator binary is not rebuilt"),
+			Help:                  statusFlagSet.Bool("help", false, "Help"),
+		},
+		History: migrator.HistoryOptions{
+			Version:    historyFlagSet.String("version", "", "Only show the history of this version"),
+			Limit:      historyFlagSet.Int("limit", 0, "Only show the last N entries"),
+			Production: historyFlagSet.Bool("production", false, "set this to true for production so that any supplied migrator binary is not rebuilt"),
+			Help:       historyFlagSet.Bool("help", false, "Help"),
+		},
+		Verify: migrator.VerifyOptions{
+			Version:    verifyFlagSet.String("version", "", "Only verify this version"),
+			Output:     verifyFlagSet.String("output", "text", "Output format, text or json"),
+			Production: verifyFlagSet.Bool("production", false, "set this to true for production so that any supplied migrator binary is not rebuilt"),
+			Help:       verifyFlagSet.Bool("help", false, "Help"),
+		},
+		Baseline: migrator.BaselineOptions{
+			Version:     baselineFlagSet.String("version", "", "Record every migration up to and including this version as applied"),
+			Force:       baselineFlagSet.Bool("force", false, "Record the missing versions even if some of them are already recorded"),
+			DryRun:      baselineFlagSet.Bool("dry-run", false, "Print the versions that would be recorded without recording them"),
+			Output:      baselineFlagSet.String("output", "text", "Output format, text or json"),
+			LockTimeout: baselineFlagSet.Duration("lock-timeout", time.Minute, "How long to wait for another migrator to release the migration lock"),
+			Production:  baselineFlagSet.Bool("production", false, "set this to true for production so that any supplied migrator binary is not rebuilt"),
+			Help:        baselineFlagSet.Bool("help", false, "Help"),
+		},
+		Unlock: migrator.UnlockOptions{
+			Production: unlockFlagSet.Bool("production", false, "set this to true for production so that any supplied migrator binary is not rebuilt"),
+			Help:       unlockFlagSet.Bool("help", false, "Help"),
 		},
 	}
 
@@ -48,6 +127,12 @@ var (
 		migrate new [-help]                    Creates a new migration script in your project
 		migrate up [-help]                     Runs all pending migrations
 		migrate down [-help]                   Runs a migration down, used typically for a specific migration version
+		migrate redo [-help]                   Runs a migration down and then up again, by default the latest applied migration
+		migrate status [-help]                 Shows which migrations have run pre and post-deploy
+		migrate history [-help]                Shows when each migration step ran, how long it took and who ran it
+		migrate verify [-help]                 Re-runs the verification of applied migrations without changing the database
+		migrate baseline [-help]               Records migrations up to -version as applied without running them, to adopt an existing database
+		migrate unlock [-help]                 Breaks a stale migration lock left behind by a migrator that didn't exit cleanly
 		migrate build                          Build the migrator binary used to run migrations in production without local dependencies on the go language
 `
 )
@@ -99,6 +184,60 @@ func main() {
 			os.Exit(2)
 		}
 		migrator.DownMigration(&options.Down)
+	case "redo":
+		if err := redoMigrationFlagSet.Parse(os.Args[2:]); err != nil {
+			panic(err)
+		}
+		if *options.Redo.Help {
+			redoMigrationFlagSet.Usage()
+			os.Exit(2)
+		}
+		migrator.RedoMigration(&options.Redo)
+	case "status":
+		if err := statusFlagSet.Parse(os.Args[2:]); err != nil {
+			panic(err)
+		}
+		if *options.Status.Help {
+			statusFlagSet.Usage()
+			os.Exit(2)
+		}
+		migrator.StatusMigration(&options.Status)
+	case "history":
+		if err := historyFlagSet.Parse(os.Args[2:]); err != nil {
+			panic(err)
+		}
+		if *options.History.Help {
+			historyFlagSet.Usage()
+			os.Exit(2)
+		}
+		migrator.HistoryMigration(&options.History)
+	case "verify":
+		if err := verifyFlagSet.Parse(os.Args[2:]); err != nil {
+			panic(err)
+		}
+		if *options.Verify.Help {
+			verifyFlagSet.Usage()
+			os.Exit(2)
+		}
+		migrator.VerifyMigration(&options.Verify)
+	case "baseline":
+		if err := baselineFlagSet.Parse(os.Args[2:]); err != nil {
+			panic(err)
+		}
+		if *options.Baseline.Help {
+			baselineFlagSet.Usage()
+			os.Exit(2)
+		}
+		migrator.BaselineMigration(&options.Baseline)
+	case "unlock":
+		if err := unlockFlagSet.Parse(os.Args[2:]); err != nil {
+			panic(err)
+		}
+		if *options.Unlock.Help {
+			unlockFlagSet.Usage()
+			os.Exit(2)
+		}
+		migrator.UnlockMigration(&options.Unlock)
 	default:
 		fmt.Println(usageText)
 		os.Exit(2)
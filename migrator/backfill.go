@@ -0,0 +1,139 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Backfill describes a batched data migration walking a table, or anything else, by an increasing cursor such as
+// the primary key. Run it from a post-deploy step with Migrator.Backfill.
+type Backfill struct {
+	// Batch processes up to size rows after cursor, and returns the cursor of the last row it processed and how many
+	// rows it processed. The backfill is done when a batch processes no rows.
+	Batch func(ctx context.Context, cursor int64, size int) (next int64, processed int, err error)
+	// Start is the cursor to start after. It is usually 0, or where a previous backfill stopped.
+	Start int64
+	// BatchSize is the size passed to Batch. Defaults to 1000.
+	BatchSize int
+	// RowsPerSecond limits how fast rows are processed by waiting between batches. 0 means no limit.
+	RowsPerSecond float64
+	// ShouldPause is asked before every batch whether to wait, such as while replication lag is high. While it
+	// returns true the backfill asks again every PauseInterval.
+	ShouldPause func(ctx context.Context) (bool, error)
+	// PauseInterval defaults to 5 seconds.
+	PauseInterval time.Duration
+	// Total is the number of rows expected, if known, for progress reporting.
+	Total int64
+}
+
+// Backfill runs b until a batch processes no rows, ctx is canceled or a batch fails, reporting the cursor through the
+// migration output and the progress through Progress, at most as often as Progress reports it. With a
+// CheckpointStore the cursor and the rows done are checkpointed after every batch, and a rerun of the failed step
+// resumes after it. Errors say which cursor the backfill stopped at.
+func (m *Migrator) Backfill(ctx context.Context, b Backfill) error {
+	if b.BatchSize <= 0 {
+		b.BatchSize = 1000
+	}
+	if b.PauseInterval <= 0 {
+		b.PauseInterval = 5 * time.Second
+	}
+
+	cursor, done, err := m.backfillCursor(b.Start)
+	if err != nil {
+		return err
+	}
+	if m.progress != nil {
+		m.progress.resume(done)
+	}
+	reported := time.Time{}
+	for {
+		if err := m.waitForBackfill(ctx, b, cursor); err != nil {
+			return err
+		}
+
+		started := time.Now()
+		next, processed, err := b.Batch(ctx, cursor, b.BatchSize)
+		if err != nil {
+			return fmt.Errorf("backfill batch after cursor %d failed: %w", cursor, err)
+		}
+		if processed == 0 {
+			m.stepOutput(fmt.Sprintf("Backfill done: %d row(s), stopped at cursor %d", done, cursor))
+			return nil
+		}
+		if next <= cursor {
+			return fmt.Errorf("backfill batch after cursor %d processed %d row(s) but returned cursor %d, which doesn't advance", cursor, processed, next)
+		}
+		cursor, done = next, done+int64(processed)
+		if err := m.saveBackfillCursor(cursor, done); err != nil {
+			return err
+		}
+		if time.Since(reported) >= progressInterval {
+			m.stepOutput(fmt.Sprintf("Backfilled %d row(s) up to cursor %d", done, cursor))
+			reported = time.Now()
+		}
+		m.Progress(done, b.Total)
+
+		if b.RowsPerSecond > 0 {
+			wait := time.Duration(float64(processed)/b.RowsPerSecond*float64(time.Second)) - time.Since(started)
+			if err := sleepContext(ctx, wait); err != nil {
+				return fmt.Errorf("backfill stopped at cursor %d: %w", cursor, err)
+			}
+		}
+	}
+}
+
+// waitForBackfill returns once the backfill can run its next batch, or with an error if ctx is canceled or
+// ShouldPause fails.
+func (m *Migrator) waitForBackfill(ctx context.Context, b Backfill, cursor int64) error {
+	paused := false
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("backfill stopped at cursor %d: %w", cursor, err)
+		}
+		if b.ShouldPause == nil {
+			return nil
+		}
+		pause, err := b.ShouldPause(ctx)
+		if err != nil {
+			return fmt.Errorf("backfill stopped at cursor %d: %w", cursor, err)
+		}
+		if !pause {
+			if paused {
+				m.stepOutput(fmt.Sprintf("Backfill resumed at cursor %d", cursor))
+			}
+			return nil
+		}
+		if !paused {
+			m.stepOutput(fmt.Sprintf("Backfill paused at cursor %d", cursor))
+			paused = true
+		}
+		if err := sleepContext(ctx, b.PauseInterval); err != nil {
+			return fmt.Errorf("backfill stopped at cursor %d: %w", cursor, err)
+		}
+	}
+}
+
+// stepOutput prints s as output of the migration whose step is running, or as a plain message outside a step.
+func (m *Migrator) stepOutput(s string) {
+	if m.progress != nil {
+		m.progress.mig.Output(s)
+		return
+	}
+	m.output().message(s)
+}
+
+// sleepContext waits for d, returning ctx's error if it is canceled first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
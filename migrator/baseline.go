@@ -0,0 +1,95 @@
+package migrator
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Baseline adopts a database whose schema was created outside of the migrator, by recording every registered
+// migration up to and including opts.Version as applied, pre and post-deploy, without running it. It refuses if any
+// of those migrations are already recorded, unless opts.Force is set, in which case only the missing versions are
+// recorded. opts.DryRun reports what would be recorded instead.
+func (m *Migrator) Baseline(ctx context.Context, opts RunOptions) (*Report, error) {
+	version := normalizeVersion(opts.Version)
+	if version == "" {
+		return nil, &OptionsError{Message: "Cannot baseline without a version specified, use -version", exitCode: 6}
+	}
+	if opts.PreDeployOnly || opts.PostDeployOnly || opts.ToVersion != "" || opts.Steps != 0 || opts.Parallel != 0 || opts.ResetCheckpoints {
+		return nil, &OptionsError{Message: "Only -version, -force and -dry-run can be used with baseline"}
+	}
+	if opts.LockTimeout <= 0 {
+		opts.LockTimeout = time.Minute
+	}
+
+	report := &Report{DryRun: opts.DryRun}
+	if err := m.sortMigrations(); err != nil {
+		return report, err
+	}
+	last := m.indexOf(version)
+	if last < 0 {
+		return report, &OptionsError{Message: "Cannot baseline to version " + version + ", it is not a registered migration"}
+	}
+
+	if !opts.DryRun {
+		var err error
+		if ctx, err = m.lock(ctx, opts.LockTimeout); err != nil {
+			return report, err
+		}
+		defer m.release()
+	}
+	if _, err := m.setRunStates(); err != nil {
+		return report, err
+	}
+
+	migrations := m.Migrations[:last+1]
+	if !opts.Force {
+		recorded := []string{}
+		for _, mig := range migrations {
+			if mig.preHasRun || mig.postHasRun {
+				recorded = append(recorded, mig.FormattedNumber)
+			}
+		}
+		if len(recorded) > 0 {
+			return report, &OptionsError{Message: "Cannot baseline, these migrations are already recorded, use -force to record the rest: " + strings.Join(recorded, ", "), exitCode: 3}
+		}
+	}
+
+	m.report = &runReport{Report: report}
+	defer func() { m.report = nil }()
+
+	for _, mig := range migrations {
+		for _, scope := range []scope{scopePreMigration, scopePostMigration} {
+			if (scope == scopePreMigration && mig.preHasRun) || (scope == scopePostMigration && mig.postHasRun) {
+				continue
+			}
+			if opts.DryRun {
+				report.Steps = append(report.Steps, StepReport{Version: mig.OrderingNumber, Name: mig.Name, Scope: string(scope), Direction: "baseline"})
+				continue
+			}
+			if ctx.Err() != nil {
+				return report, stopError(ctx)
+			}
+			if err := m.recordBaseline(ctx, mig, scope); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// recordBaseline records one scope of a migration as applied without running it.
+func (m *Migrator) recordBaseline(ctx context.Context, mig *Migration, scope scope) error {
+	m.startStep(ctx, mig, string(scope), "baseline")
+	start := time.Now()
+	var err error
+	if err = m.DbDriver.InsertVersion(string(scope), mig.OrderingNumber); err != nil {
+		err = &DriverError{Op: "insert version", Err: err}
+	} else {
+		mig.Output("Recorded " + string(scope) + "-deploy as applied without running it (" + mig.Name + ")")
+		m.recordChecksum(mig)
+		mig.setHasRun(scope, true)
+	}
+	m.finishStep(ctx, mig, string(scope), "baseline", start, err)
+	return err
+}
@@ -0,0 +1,183 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrCheckpointsNotSupported is returned when saving or loading a checkpoint with a DbDriver that doesn't implement
+// CheckpointStore.
+var ErrCheckpointsNotSupported = errors.New("the database driver does not store checkpoints")
+
+// CheckpointStore is an optional interface a DbDriver can implement to persist how far a step has got, so that a
+// step that fails part way resumes where it left off on the next run. step is the scope and direction of the step,
+// such as "post-up". Checkpoints of a step are cleared once it completes, and the up checkpoints of a migration once
+// any of its down steps completes.
+//
+// Checkpoints must be written outside of the transaction the PreMigration hook starts for each step: the PostFailure
+// hook rolls that transaction back when the step fails, which would lose the checkpoint the next run resumes from.
+type CheckpointStore interface {
+	SaveCheckpoint(version int64, step, key, value string) error
+	LoadCheckpoint(version int64, step, key string) (value string, ok bool, err error)
+	ClearCheckpoints(version int64, step string) error
+}
+
+// SaveCheckpoint persists how far the running step has got, such as the last id it processed. A step that saved a
+// checkpoint is not rolled back when it fails, so that the next run can resume it from LoadCheckpoint. Within a
+// SubStep the checkpoint belongs to the sub-step.
+func (m *Migrator) SaveCheckpoint(value string) error {
+	return m.saveCheckpoint("checkpoint", value)
+}
+
+// LoadCheckpoint returns the checkpoint saved by a previous attempt of the running step, if there is one.
+func (m *Migrator) LoadCheckpoint() (string, bool, error) {
+	return m.loadCheckpoint("checkpoint")
+}
+
+// SubStep runs f as a named part of the running step, unless a previous attempt of the step already completed it.
+// f gets a Migrator whose checkpoints belong to the sub-step. Without a CheckpointStore, f always runs.
+func (m *Migrator) SubStep(name string, f func(m *Migrator) error) error {
+	key := "substep:" + name
+	if _, done, err := m.loadCheckpoint(key); err != nil && err != ErrCheckpointsNotSupported {
+		return err
+	} else if done {
+		m.stepOutput("Skipping sub-step " + name + ", it completed in a previous run")
+		return nil
+	}
+
+	sub := *m
+	sub.checkpointPrefix = m.checkpointPrefix + name + "/"
+	if err := f(&sub); err != nil {
+		return err
+	}
+	if err := m.saveCheckpoint(key, "done"); err != nil && err != ErrCheckpointsNotSupported {
+		return err
+	}
+	return nil
+}
+
+func (m *Migrator) checkpointStore() (CheckpointStore, *stepProgress, error) {
+	store, ok := m.DbDriver.(CheckpointStore)
+	if !ok {
+		return nil, nil, ErrCheckpointsNotSupported
+	}
+	if m.progress == nil {
+		return nil, nil, errors.New("checkpoints can only be used from a migration step")
+	}
+	return store, m.progress, nil
+}
+
+func (m *Migrator) saveCheckpoint(key, value string) error {
+	store, step, err := m.checkpointStore()
+	if err != nil {
+		return err
+	}
+	if err := store.SaveCheckpoint(step.mig.OrderingNumber, step.name(), m.checkpointPrefix+key, value); err != nil {
+		return &DriverError{Op: "save checkpoint", Err: err}
+	}
+	step.mu.Lock()
+	step.checkpointed = true
+	step.mu.Unlock()
+	return nil
+}
+
+func (m *Migrator) loadCheckpoint(key string) (string, bool, error) {
+	store, step, err := m.checkpointStore()
+	if err != nil {
+		return "", false, err
+	}
+	value, ok, err := store.LoadCheckpoint(step.mig.OrderingNumber, step.name(), m.checkpointPrefix+key)
+	if err != nil {
+		return "", false, &DriverError{Op: "load checkpoint", Err: err}
+	}
+	return value, ok, nil
+}
+
+// clearCheckpoints removes the checkpoints of a step, once it has completed or when they are reset with -force.
+func (m *Migrator) clearCheckpoints(mig *Migration, scope scope, direction direction) error {
+	store, ok := m.DbDriver.(CheckpointStore)
+	if !ok {
+		return nil
+	}
+	if err := store.ClearCheckpoints(mig.OrderingNumber, string(scope)+"-"+string(direction)); err != nil {
+		return &DriverError{Op: "clear checkpoints", Err: err}
+	}
+	return nil
+}
+
+// clearCompletedCheckpoints clears the checkpoints of a step that completed. When a down step completes, the up
+// checkpoints of the migration are cleared too, so that a failed up step that was abandoned by running the migration
+// down starts over on the next up instead of resuming.
+func (m *Migrator) clearCompletedCheckpoints(mig *Migration, completed scope, direction direction) error {
+	if err := m.clearCheckpoints(mig, completed, direction); err != nil {
+		return err
+	}
+	if direction != directionDown {
+		return nil
+	}
+	for _, s := range []scope{scopePreMigration, scopePostMigration} {
+		if err := m.clearCheckpoints(mig, s, directionUp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetCheckpoints clears the checkpoints of the planned steps, so they start over.
+func (m *Migrator) resetCheckpoints(steps []migrationStep) error {
+	for _, step := range steps {
+		if step.verify {
+			continue
+		}
+		if err := m.clearCheckpoints(step.mig, step.scope, step.direction); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resumable reports whether the last step run of the migration saved a checkpoint.
+func (mig *Migration) resumable() bool {
+	p := mig.progress
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.checkpointed
+}
+
+// backfillCursor returns the cursor and the number of rows done saved by a previous attempt of a backfill in the
+// running step, or start and 0.
+func (m *Migrator) backfillCursor(start int64) (int64, int64, error) {
+	value, ok, err := m.loadCheckpoint("backfill")
+	if err != nil || !ok {
+		if err == ErrCheckpointsNotSupported || m.progress == nil {
+			err = nil
+		}
+		return start, 0, err
+	}
+	cursorValue, doneValue, _ := strings.Cut(value, " ")
+	cursor, err := strconv.ParseInt(cursorValue, 10, 64)
+	if err != nil {
+		return start, 0, err
+	}
+	done, err := strconv.ParseInt(doneValue, 10, 64)
+	if err != nil {
+		return start, 0, err
+	}
+	m.stepOutput(fmt.Sprintf("Resuming backfill after cursor %d, %d row(s) already done", cursor, done))
+	return cursor, done, nil
+}
+
+// saveBackfillCursor checkpoints the cursor of a backfill and the number of rows done, if the DbDriver stores
+// checkpoints.
+func (m *Migrator) saveBackfillCursor(cursor, done int64) error {
+	value := strconv.FormatInt(cursor, 10) + " " + strconv.FormatInt(done, 10)
+	if err := m.saveCheckpoint("backfill", value); err != nil && err != ErrCheckpointsNotSupported && m.progress != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package migrator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ChecksumFileName is the file `migrate build` generates next to the main migrator file, registering the checksum
+// of every migration file with the migrator binary.
+var ChecksumFileName = "migrator_checksums.go"
+
+// ChecksumStore is an optional interface a DbDriver can implement to record the checksum of a migration's source
+// when it is applied, so migrations edited afterwards can be detected. InsertChecksum is called for each scope that
+// is applied, and should replace any checksum already recorded for the version.
+type ChecksumStore interface {
+	InsertChecksum(version int64, checksum string) error
+	GetChecksums() (map[int64]string, error)
+}
+
+// checksums holds the checksum of every migration file, by version, as registered by the generated checksum file.
+var checksums = map[int64]string{}
+
+// SetChecksums registers the checksums of the migration files. It is called by the file `migrate build` generates,
+// and isn't meant to be called otherwise.
+func SetChecksums(c map[int64]string) {
+	checksums = c
+}
+
+// SetChecksumPolicy sets what happens when an applied migration's source no longer matches the checksum recorded
+// when it was applied. The default is PolicyWarn.
+func (m *Migrator) SetChecksumPolicy(p Policy) {
+	m.checksumPolicy = p
+}
+
+// recordChecksum records the checksum of the migration's source as it is applied, if the DbDriver stores them.
+func (m *Migrator) recordChecksum(mig *Migration) {
+	store, ok := m.DbDriver.(ChecksumStore)
+	checksum, known := checksums[mig.OrderingNumber]
+	if !ok || !known {
+		return
+	}
+	if err := store.InsertChecksum(mig.OrderingNumber, checksum); err != nil {
+		m.output().warn("could not record the checksum of migration " + mig.FormattedNumber + ": " + err.Error())
+	}
+}
+
+// modifiedMigrations returns the applied migrations whose source doesn't match the checksum recorded when they
+// were applied. It relies on the run states set by setRunStates.
+func (m *Migrator) modifiedMigrations() ([]*Migration, error) {
+	store, ok := m.DbDriver.(ChecksumStore)
+	if !ok {
+		return nil, nil
+	}
+	recorded, err := store.GetChecksums()
+	if err != nil {
+		return nil, &DriverError{Op: "get checksums", Err: err}
+	}
+
+	modified := []*Migration{}
+	for _, mig := range m.Migrations {
+		if !mig.preHasRun && !mig.postHasRun {
+			continue
+		}
+		current, known := checksums[mig.OrderingNumber]
+		applied, recordedOk := recorded[mig.OrderingNumber]
+		if known && recordedOk && current != applied {
+			modified = append(modified, mig)
+		}
+	}
+	return modified, nil
+}
+
+// checkChecksums applies the checksum policy to migrations modified since they were applied. The migration being
+// forced, if any, is left out so that re-running it is a way to accept the change.
+func (m *Migrator) checkChecksums(forced string) error {
+	if m.checksumPolicy == PolicyAllow {
+		return nil
+	}
+	modified, err := m.modifiedMigrations()
+	if err != nil {
+		return err
+	}
+
+	versions := []string{}
+	for _, mig := range modified {
+		if strconv.FormatInt(mig.OrderingNumber, 10) == forced {
+			continue
+		}
+		versions = append(versions, mig.FormattedNumber+" ("+mig.Name+")")
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+	if m.checksumPolicy == PolicyError {
+		return &ChecksumError{Migrations: versions}
+	}
+	for _, version := range versions {
+		m.output().warn("migration " + version + " has been modified since it was applied")
+	}
+	return nil
+}
+
+// writeChecksumFile generates the file registering the checksum of every migration file with the migrator binary,
+// and returns its path.
+func writeChecksumFile() string {
+	files, err := ioutil.ReadDir(config.LocalMigrationsPath)
+	if err != nil {
+		panic("Could not read migrations folder " + config.LocalMigrationsPath + ": " + err.Error())
+	}
+
+	sums := map[int64]string{}
+	for _, file := range files {
+		version, ok := migrationFileVersion(file.Name())
+		if !ok {
+			continue
+		}
+		content, err := ReadFile(filepath.Join(config.LocalMigrationsPath, file.Name()))
+		if err != nil {
+			panic("Could not checksum migration: " + err.Error())
+		}
+		sum := sha256.Sum256(content)
+		sums[version] = hex.EncodeToString(sum[:])
+	}
+
+	versions := []int64{}
+	for version := range sums {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(a, b int) bool { return versions[a] < versions[b] })
+
+	source := bytes.Buffer{}
+	source.WriteString("// Code generated by migrate build. DO NOT EDIT.\n\npackage main\n\n")
+	source.WriteString("import \"github.com/ssoroka/gomigrate/migrator\"\n\nfunc init() {\n\tmigrator.SetChecksums(map[int64]string{\n")
+	for _, version := range versions {
+		fmt.Fprintf(&source, "\t\t%d: %q,\n", version, sums[version])
+	}
+	source.WriteString("\t})\n}\n")
+
+	path := filepath.Join(config.LocalMigratorPath, ChecksumFileName)
+	if err := WriteFile(path, source.Bytes()); err != nil {
+		panic("Could not write checksum file: " + err.Error())
+	}
+	return path
+}
+
+// migrationFileVersion returns the version of a migration file created by `migrate new`, named like
+// 2017_10_25_00001_add_users.go.
+func migrationFileVersion(name string) (int64, bool) {
+	if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+		return 0, false
+	}
+	parts := strings.SplitN(name, "_", 5)
+	if len(parts) < 5 || len(parts[0]) != 4 || len(parts[1]) != 2 || len(parts[2]) != 2 || len(parts[3]) != 5 {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(parts[0]+parts[1]+parts[2]+parts[3], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
@@ -16,6 +16,18 @@ type Config struct {
 	LocalMigrationsPath    string
 	LocalMigrationsPackage string
 	LocalTemplatesPath     string
+	// ChecksumPolicy is passed to the migrator as -checksum-policy unless given on the command line.
+	ChecksumPolicy string `json:",omitempty"`
+	// OutOfOrderPolicy is passed to the migrator as -out-of-order unless given on the command line.
+	OutOfOrderPolicy string `json:",omitempty"`
+	// UnknownVersionsPolicy is passed to the migrator as -unknown-versions unless given on the command line.
+	UnknownVersionsPolicy string `json:",omitempty"`
+	// MissingDownPolicy is passed to the migrator as -missing-down unless given on the command line.
+	MissingDownPolicy string `json:",omitempty"`
+	// RetryAttempts and RetryBackoff (such as "2s") are passed to the migrator as -retry-attempts and -retry-backoff
+	// unless given on the command line.
+	RetryAttempts int    `json:",omitempty"`
+	RetryBackoff  string `json:",omitempty"`
 }
 
 func DefaultConfig() *Config {
@@ -0,0 +1,85 @@
+package migrator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DependsOn makes the migration run after the given versions, whatever their timestamps, and run down before them.
+// Migrations without dependencies between them still run in timestamp order.
+func (m *Migration) DependsOn(versions ...int64) *Migration {
+	m.dependsOn = append(m.dependsOn, versions...)
+	return m
+}
+
+// sortMigrations orders the registered migrations so that every migration comes after its dependencies, using the
+// timestamp to order migrations that don't depend on each other.
+func (m *Migrator) sortMigrations() error {
+	sort.Sort(m.Migrations)
+	registered := map[int64]bool{}
+	for _, mig := range m.Migrations {
+		registered[mig.OrderingNumber] = true
+	}
+	for _, mig := range m.Migrations {
+		for _, version := range mig.dependsOn {
+			if !registered[version] {
+				return &DependencyError{Message: fmt.Sprintf("Migration %s (%s) depends on version %s, which is not registered", mig.FormattedNumber, mig.Name, formatVersion(version))}
+			}
+		}
+	}
+
+	sorted, err := orderByDependencies(m.Migrations)
+	if err != nil {
+		return err
+	}
+	copy(m.Migrations, sorted)
+	return nil
+}
+
+// orderByDependencies returns migrations, which must be sorted by timestamp, with every migration moved after its
+// dependencies. Dependencies on versions that aren't in migrations are ignored, but two migrations with the same
+// version are an error, since neither could be depended on.
+func orderByDependencies(migrations SortableMigrations) (SortableMigrations, error) {
+	pending := map[int64]bool{}
+	for i, mig := range migrations {
+		if pending[mig.OrderingNumber] {
+			// migrations are sorted, so the other one is just before it.
+			other := migrations[i-1]
+			return nil, &DependencyError{Message: fmt.Sprintf("Migrations %s (%s) and %s (%s) have the same version", other.FormattedNumber, other.Name, mig.FormattedNumber, mig.Name)}
+		}
+		pending[mig.OrderingNumber] = true
+	}
+
+	sorted := SortableMigrations{}
+	for len(sorted) < len(migrations) {
+		next := -1
+		for i, mig := range migrations {
+			if pending[mig.OrderingNumber] && !waitsOnPending(mig, pending) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			cycle := []string{}
+			for _, mig := range migrations {
+				if pending[mig.OrderingNumber] {
+					cycle = append(cycle, mig.FormattedNumber+" ("+mig.Name+")")
+				}
+			}
+			return nil, &DependencyError{Message: "The dependencies of these migrations form a cycle: " + strings.Join(cycle, ", ")}
+		}
+		delete(pending, migrations[next].OrderingNumber)
+		sorted = append(sorted, migrations[next])
+	}
+	return sorted, nil
+}
+
+func waitsOnPending(mig *Migration, pending map[int64]bool) bool {
+	for _, version := range mig.dependsOn {
+		if pending[version] {
+			return true
+		}
+	}
+	return false
+}
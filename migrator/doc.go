@@ -0,0 +1,7 @@
+// Package migrator runs gomigrate migrations, from the migrator binary built by the migrate command or from your own
+// code with Up, Down and friends.
+//
+// The errors that reject a run because of its options, the registered migrations or the versions applied to the
+// database (*OptionsError, *DependencyError, *ChecksumError, *OutOfOrderError, *UnknownVersionsError and
+// *IrreversibleError) are returned before any step runs, so nothing has been run when they are returned.
+package migrator
@@ -0,0 +1,176 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrLockingNotSupported is returned by BreakLock when the DbDriver doesn't implement Locker.
+var ErrLockingNotSupported = errors.New("the database driver does not support locking")
+
+// OptionsError is returned when the options for a run are invalid or contradict each other.
+type OptionsError struct {
+	Message string
+
+	// exitCode is the status the migrator binary exits with, kept from before the options were validated here.
+	exitCode int
+}
+
+func (e *OptionsError) Error() string {
+	return e.Message
+}
+
+// StepError is returned when a migration step, or the verification of a migration, fails. By the time it is
+// returned the migration has been rolled back as far as possible.
+type StepError struct {
+	Version   int64
+	Name      string
+	Scope     string // "pre" or "post", empty for a verification
+	Direction string // "up", "down" or "verify"
+	Err       error
+}
+
+func newStepError(step migrationStep, err error) *StepError {
+	e := &StepError{
+		Version:   step.mig.OrderingNumber,
+		Name:      step.mig.Name,
+		Scope:     string(step.scope),
+		Direction: string(step.direction),
+		Err:       err,
+	}
+	if step.verify {
+		e.Direction = "verify"
+	}
+	return e
+}
+
+func (e *StepError) Error() string {
+	step := e.Scope + "-" + e.Direction
+	if e.Scope == "" {
+		step = e.Direction
+	}
+	return fmt.Sprintf("%s of migration %d (%s) failed: %v", step, e.Version, e.Name, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is the error of a step that ran longer than its timeout. It matches context.DeadlineExceeded with
+// errors.Is.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return "timed out after " + e.Timeout.String()
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// CanceledError is returned when the context is canceled before a run finishes. The run stops between steps, so
+// the steps in the Report completed and nothing after them was started.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return "migration run canceled: " + e.Err.Error()
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// ChecksumError is returned when the checksum policy is PolicyError and applied migrations have been modified since
+// they were applied.
+type ChecksumError struct {
+	Migrations []string
+}
+
+func (e *ChecksumError) Error() string {
+	return "migrations modified since they were applied: " + strings.Join(e.Migrations, ", ")
+}
+
+// DependencyError is returned when the dependencies between migrations can't be satisfied, because a migration
+// depends on a version that isn't registered, two migrations have the same version or the dependencies form a cycle.
+type DependencyError struct {
+	Message string
+}
+
+func (e *DependencyError) Error() string {
+	return e.Message
+}
+
+// OutOfOrderError is returned when the out-of-order policy is PolicyError and pending migrations would run after
+// migrations ordered later have already been applied.
+type OutOfOrderError struct {
+	Migrations []string
+}
+
+func (e *OutOfOrderError) Error() string {
+	return "migrations out of order: " + strings.Join(e.Migrations, "; ")
+}
+
+// UnknownVersionsError is returned when the unknown versions policy is PolicyError and versions have been applied
+// that aren't registered with the Migrator.
+type UnknownVersionsError struct {
+	Versions []string
+}
+
+func (e *UnknownVersionsError) Error() string {
+	return "versions applied but not registered: " + strings.Join(e.Versions, ", ")
+}
+
+// DriverError is returned when a call to the DbDriver fails, such as reading or recording versions or taking the
+// migration lock.
+type DriverError struct {
+	Op  string
+	Err error
+}
+
+func (e *DriverError) Error() string {
+	return "database driver failed to " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *DriverError) Unwrap() error {
+	return e.Err
+}
+
+// exitCode is the status the migrator binary exits with for err.
+func exitCode(err error) int {
+	var optionsErr *OptionsError
+	var dependencyErr *DependencyError
+	var stepErr *StepError
+	var verifyErr *VerifyError
+	var parallelErr *ParallelError
+	var driverErr *DriverError
+	var canceledErr *CanceledError
+	var checksumErr *ChecksumError
+	var outOfOrderErr *OutOfOrderError
+	var unknownVersionsErr *UnknownVersionsError
+	var irreversibleErr *IrreversibleError
+	switch {
+	case errors.As(err, &optionsErr):
+		if optionsErr.exitCode != 0 {
+			return optionsErr.exitCode
+		}
+		return 2
+	case errors.As(err, &dependencyErr):
+		return 2
+	case errors.As(err, &stepErr), errors.As(err, &verifyErr), errors.As(err, &parallelErr):
+		return 4
+	case errors.As(err, &driverErr):
+		return 5
+	case errors.As(err, &canceledErr):
+		return 130
+	case errors.As(err, &checksumErr), errors.As(err, &outOfOrderErr), errors.As(err, &unknownVersionsErr), errors.As(err, &irreversibleErr):
+		return 7
+	}
+	return 1
+}
@@ -0,0 +1,162 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// ErrHistoryNotSupported is returned by History when the DbDriver doesn't implement HistoryRecorder.
+var ErrHistoryNotSupported = errors.New("the database driver does not record migration history")
+
+// HistoryRecorder is an optional interface a DbDriver can implement to keep a record of every migration step that
+// runs, on top of the versions that are currently applied.
+type HistoryRecorder interface {
+	RecordHistory(entry HistoryEntry) error
+	GetHistory() ([]HistoryEntry, error)
+}
+
+// HistoryEntry records a single migration step that ran.
+type HistoryEntry struct {
+	Version    int64
+	Name       string
+	Scope      string // "pre" or "post", empty for a verification
+	Direction  string // "up", "down", "verify" or "baseline"
+	Rollback   bool   // the step ran to undo a failed step
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Duration   time.Duration
+	Host       string
+	User       string
+	// Build identifies the migrator binary that ran the step: when and where it was built, or its path and mod time
+	// if it wasn't built by the migrate command, followed by the Go version.
+	Build string
+	// Error is empty if the step succeeded.
+	Error string
+}
+
+var (
+	runInfoOnce              sync.Once
+	runHost, runUser, runBin string
+
+	// buildStamp is set by buildMigrationBinary with -ldflags -X to when and where the migrator binary was built.
+	buildStamp string
+)
+
+// loadRunInfo looks up the host, OS user and build of this process, once.
+func loadRunInfo() {
+	runInfoOnce.Do(func() {
+		runHost, _ = os.Hostname()
+		if u, err := user.Current(); err == nil {
+			runUser = u.Username
+		} else {
+			runUser = os.Getenv("USER")
+		}
+		runBin = buildStamp
+		if runBin == "" {
+			if exe, err := os.Executable(); err == nil {
+				runBin = exe
+				if stat, err := os.Stat(exe); err == nil {
+					runBin += " modified " + stat.ModTime().UTC().Format(time.RFC3339)
+				}
+			}
+		}
+		if info, ok := debug.ReadBuildInfo(); ok {
+			runBin += " (" + info.GoVersion + ")"
+		}
+	})
+}
+
+// recordHistory records a step that ran, if the DbDriver keeps history. A failure to record it is reported but
+// doesn't fail the step.
+func (m *Migrator) recordHistory(step StepReport, start time.Time) {
+	recorder, ok := m.DbDriver.(HistoryRecorder)
+	if !ok {
+		return
+	}
+	loadRunInfo()
+	entry := HistoryEntry{
+		Version:    step.Version,
+		Name:       step.Name,
+		Scope:      step.Scope,
+		Direction:  step.Direction,
+		Rollback:   step.Rollback,
+		StartedAt:  start,
+		FinishedAt: start.Add(step.Duration),
+		Duration:   step.Duration,
+		Host:       runHost,
+		User:       runUser,
+		Build:      runBin,
+	}
+	if step.Err != nil {
+		entry.Error = step.Err.Error()
+	}
+	if err := recorder.RecordHistory(entry); err != nil {
+		m.output().warn("could not record migration history: " + err.Error())
+	}
+}
+
+// History returns every recorded migration step, oldest first. It returns ErrHistoryNotSupported if the DbDriver
+// isn't a HistoryRecorder.
+func (m *Migrator) History() ([]HistoryEntry, error) {
+	recorder, ok := m.DbDriver.(HistoryRecorder)
+	if !ok {
+		return nil, ErrHistoryNotSupported
+	}
+	entries, err := recorder.GetHistory()
+	if err != nil {
+		return nil, &DriverError{Op: "get history", Err: err}
+	}
+	sort.SliceStable(entries, func(a, b int) bool {
+		return entries[a].StartedAt.Before(entries[b].StartedAt)
+	})
+	return entries, nil
+}
+
+// printHistory prints the recorded migration steps for version, or for every version if it is empty, keeping only
+// the last limit entries when limit is positive.
+func (m *Migrator) printHistory(w io.Writer, version string, limit int) error {
+	entries, err := m.History()
+	if err != nil {
+		return err
+	}
+	if version != "" {
+		filtered := []HistoryEntry{}
+		for _, entry := range entries {
+			if strconv.FormatInt(entry.Version, 10) == version {
+				filtered = append(filtered, entry)
+			}
+		}
+		entries = filtered
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STARTED\tVERSION\tNAME\tSTEP\tDURATION\tHOST\tUSER\tBUILD\tRESULT")
+	for _, entry := range entries {
+		step := entry.Direction
+		if entry.Scope != "" {
+			step = entry.Scope + "-" + entry.Direction
+		}
+		if entry.Rollback {
+			step += " (rollback)"
+		}
+		result := "ok"
+		if entry.Error != "" {
+			result = "failed: " + entry.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.StartedAt.Local().Format(time.RFC3339), formatVersion(entry.Version),
+			entry.Name, step, entry.Duration.Round(time.Millisecond), entry.Host, entry.User, entry.Build, result)
+	}
+	return tw.Flush()
+}
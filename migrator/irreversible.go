@@ -0,0 +1,63 @@
+package migrator
+
+// IrreversibleError is returned when running down migrations that can't be undone, without -force.
+type IrreversibleError struct {
+	Migrations []string
+}
+
+func (e *IrreversibleError) Error() string {
+	message := "Cannot run down irreversible migrations, use -force with -version to mark them as not applied anyway:"
+	for _, mig := range e.Migrations {
+		message += "\n  " + mig
+	}
+	return message
+}
+
+// Irreversible marks the migration as one that can't be undone, such as one dropping data. Running it down fails
+// unless forced, in which case its down functions, if any, run as usual.
+func (m *Migration) Irreversible(reason string) *Migration {
+	m.irreversible = true
+	m.irreversibleReason = reason
+	return m
+}
+
+// SetMissingDownPolicy sets what happens when running down a migration step that has an up function but no down
+// function, which would mark the step as not applied without undoing it. The default is PolicyWarn.
+func (m *Migrator) SetMissingDownPolicy(p Policy) {
+	m.missingDownPolicy = p
+}
+
+// checkReversible fails the planned down steps of irreversible migrations, and applies the missing down policy to
+// steps without a down function, unless force is set.
+func (m *Migrator) checkReversible(steps []migrationStep, force bool) error {
+	if force {
+		return nil
+	}
+	irreversible := []string{}
+	seen := map[int64]bool{}
+	for _, step := range steps {
+		mig := step.mig
+		if step.direction != directionDown || seen[mig.OrderingNumber] {
+			continue
+		}
+		if mig.irreversible {
+			seen[mig.OrderingNumber] = true
+			irreversible = append(irreversible, mig.FormattedNumber+" ("+mig.Name+"): "+mig.irreversibleReason)
+			continue
+		}
+		if mig.stepFunc(step.scope, directionUp) == nil || mig.stepFunc(step.scope, directionDown) != nil {
+			continue
+		}
+		switch m.missingDownPolicy {
+		case PolicyError:
+			seen[mig.OrderingNumber] = true
+			irreversible = append(irreversible, mig.FormattedNumber+" ("+mig.Name+"): no "+string(step.scope)+"-down function")
+		case PolicyWarn, "":
+			m.output().warn("migration " + mig.FormattedNumber + " (" + mig.Name + ") has no " + string(step.scope) + "-down function, it will be marked as not applied without being undone")
+		}
+	}
+	if len(irreversible) > 0 {
+		return &IrreversibleError{Migrations: irreversible}
+	}
+	return nil
+}
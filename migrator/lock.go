@@ -0,0 +1,107 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const defaultLockRenewInterval = 30 * time.Second
+
+// Locker is an optional interface a DbDriver can implement to stop two migrators, typically on different deploy
+// hosts, from running migrations at the same time. The lock is expected to be a lease that expires if it isn't
+// renewed, so a crashed migrator can't hold it forever.
+type Locker interface {
+	// Lock acquires the migration lock, waiting up to timeout for another holder to release it.
+	Lock(timeout time.Duration) error
+	// RenewLock extends the lease on the lock held by this migrator.
+	RenewLock() error
+	// Unlock releases the lock held by this migrator.
+	Unlock() error
+	// ForceUnlock breaks the lock no matter who holds it, to clean up after a migrator that died holding it.
+	ForceUnlock() error
+}
+
+// SetLockRenewInterval sets how often the migration lock is renewed while migrations run, when the DbDriver is a
+// Locker. It should be comfortably shorter than the lease the driver takes out.
+func (m *Migrator) SetLockRenewInterval(d time.Duration) {
+	m.lockRenewInterval = d
+}
+
+// lock takes the migration lock if the DbDriver supports it, and keeps renewing it until release is called. The
+// returned context is canceled if a renewal fails, since another migrator may take the lock once the lease expires.
+func (m *Migrator) lock(ctx context.Context, timeout time.Duration) (context.Context, error) {
+	locker, ok := m.DbDriver.(Locker)
+	if !ok {
+		return ctx, nil
+	}
+	if err := locker.Lock(timeout); err != nil {
+		return ctx, &DriverError{Op: "acquire the migration lock", Err: err}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	interval := m.lockRenewInterval
+	if interval <= 0 {
+		interval = defaultLockRenewInterval
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := locker.RenewLock(); err != nil {
+					m.output().warn("could not renew the migration lock, stopping: " + err.Error())
+					cancel(&DriverError{Op: "renew the migration lock", Err: err})
+					return
+				}
+			}
+		}
+	}()
+
+	m.releaseLock = func() {
+		close(done)
+		<-stopped
+		cancel(nil)
+		if err := locker.Unlock(); err != nil {
+			m.output().warn("could not release the migration lock: " + err.Error())
+		}
+	}
+	return ctx, nil
+}
+
+// stopError returns the error a run stops with once ctx is done: the *DriverError if the migration lock was lost,
+// otherwise a *CanceledError.
+func stopError(ctx context.Context) error {
+	var lost *DriverError
+	if errors.As(context.Cause(ctx), &lost) {
+		return lost
+	}
+	return &CanceledError{Err: ctx.Err()}
+}
+
+// release releases the migration lock if this migrator holds it.
+func (m *Migrator) release() {
+	if m.releaseLock != nil {
+		m.releaseLock()
+		m.releaseLock = nil
+	}
+}
+
+// BreakLock breaks the migration lock no matter who holds it, to clean up after a migrator that died holding it.
+// It returns ErrLockingNotSupported if the DbDriver isn't a Locker.
+func (m *Migrator) BreakLock() error {
+	locker, ok := m.DbDriver.(Locker)
+	if !ok {
+		return ErrLockingNotSupported
+	}
+	if err := locker.ForceUnlock(); err != nil {
+		return &DriverError{Op: "break the migration lock", Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package migrator
+
+import (
+	"log/slog"
+	"time"
+)
+
+// SetLogger sends everything the migrator reports on a run to logger instead of printing it: migration output,
+// steps starting, finishing and failing, warnings and the outcome of the run, with the version, name, scope and
+// direction of the step as attributes. It replaces SetOutput.
+func (m *Migrator) SetLogger(logger *slog.Logger) {
+	m.out = &output{logger: logger}
+}
+
+// Logger returns the Logger set with SetLogger, or slog.Default(). In a step function it is scoped to the step,
+// carrying its version, name, scope and direction.
+func (m *Migrator) Logger() *slog.Logger {
+	if m.stepLogger != nil {
+		return m.stepLogger
+	}
+	if o := m.output(); o.logger != nil {
+		return o.logger
+	}
+	return slog.Default()
+}
+
+// forStep returns a copy of the Migrator to hand to a step function, with a Logger and Progress scoped to the step.
+func (m *Migrator) forStep(mig *Migration, scope, direction string) *Migrator {
+	step := *m
+	step.stepLogger = m.Logger().With(stepAttrs(mig, scope, direction)...)
+	step.progress = mig.progress
+	return &step
+}
+
+func stepAttrs(mig *Migration, scope, direction string) []any {
+	attrs := []any{slog.Int64("version", mig.OrderingNumber), slog.String("name", mig.Name)}
+	if scope != "" {
+		attrs = append(attrs, slog.String("scope", scope))
+	}
+	if direction != "" {
+		attrs = append(attrs, slog.String("direction", direction))
+	}
+	return attrs
+}
+
+// logStep logs a step starting, when finished is false, or finishing.
+func (o *output) logStep(mig *Migration, scope, direction string, rollback, finished bool, duration time.Duration, err error) {
+	attrs := stepAttrs(mig, scope, direction)
+	if rollback {
+		attrs = append(attrs, slog.Bool("rollback", true))
+	}
+	switch {
+	case !finished:
+		o.logger.Info("Step started", attrs...)
+	case err != nil:
+		o.logger.Error("Step failed", append(attrs, slog.Duration("duration", duration), slog.Any("error", err))...)
+	default:
+		o.logger.Info("Step finished", append(attrs, slog.Duration("duration", duration))...)
+	}
+}
+
+// logRun logs the outcome of a run.
+func (o *output) logRun(report *Report, err error, duration time.Duration) {
+	steps, failed := 0, 0
+	if report != nil {
+		for _, step := range report.Steps {
+			if report.DryRun {
+				o.logger.Info("Planned step", slog.Int64("version", step.Version), slog.String("name", step.Name), slog.String("scope", step.Scope), slog.String("direction", step.Direction))
+			}
+		}
+		steps, failed = len(report.Steps), len(report.Failed())
+	}
+	attrs := []any{slog.Int("steps", steps), slog.Int("failed", failed), slog.Duration("duration", duration)}
+	if report != nil && report.DryRun {
+		attrs = append(attrs, slog.Bool("dry_run", true))
+	}
+	if err != nil {
+		o.logger.Error("Run failed", append(attrs, slog.Any("error", err))...)
+		return
+	}
+	o.logger.Info("Run finished", attrs...)
+}
@@ -1,6 +1,10 @@
 package migrator
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"time"
+)
 
 type Migration struct {
 	Name            string
@@ -8,54 +12,214 @@ type Migration struct {
 	FormattedNumber string
 	preHasRun       bool
 	postHasRun      bool
-	upFunc          migrationStepFunc
-	downFunc        migrationStepFunc
-	postUpFunc      migrationStepFunc
-	postDownFunc    migrationStepFunc
-	verifyFunc      migrationStepFunc
+	upFunc          ContextStepFunc
+	downFunc        ContextStepFunc
+	postUpFunc      ContextStepFunc
+	postDownFunc    ContextStepFunc
+	verifyFunc      ContextStepFunc
+
+	timeout         time.Duration
+	upTimeout       time.Duration
+	downTimeout     time.Duration
+	postUpTimeout   time.Duration
+	postDownTimeout time.Duration
+	verifyTimeout   time.Duration
+
+	allowOutOfOrder bool
+	dependsOn       []int64
+	parallelizable  bool
+
+	irreversible       bool
+	irreversibleReason string
+
+	retryAttempts int
+	retryBackoff  time.Duration
+
+	// runningScope and runningDirection describe the step running, for migration output.
+	runningScope     string
+	runningDirection string
+	progress         *stepProgress
+
+	// migrator is the Migrator the migration is registered with.
+	migrator *Migrator
 }
 
 type migrationStepFunc func(migrator *Migrator) error
 
+// ContextStepFunc is a migration step that can be canceled. The context is canceled when the migrator is
+// interrupted (SIGINT or SIGTERM) or, for library use, when the context passed to Up or Down is.
+type ContextStepFunc func(ctx context.Context, migrator *Migrator) error
+
+// withContext adapts a step that doesn't take a context.
+func withContext(f migrationStepFunc) ContextStepFunc {
+	if f == nil {
+		return nil
+	}
+	return func(ctx context.Context, migrator *Migrator) error {
+		return f(migrator)
+	}
+}
+
 func NewMigration(number int64, name string) *Migration {
 	m := &Migration{
 		OrderingNumber: number,
 		Name:           name,
 	}
+	m.FormattedNumber = formatVersion(number)
+	return m
+}
+
+// formatVersion formats a version generated by `migrate new` as 2017_10_25_00001.
+func formatVersion(number int64) string {
 	numberStr := fmt.Sprintf("%d", number)
+	if len(numberStr) < 13 {
+		// not a timestamp generated by `migrate new`
+		return numberStr
+	}
 	yearStr := numberStr[0:4]
 	monthStr := numberStr[4:6]
 	dayStr := numberStr[6:8]
 	secondsStr := numberStr[8:13]
-	m.FormattedNumber = yearStr + "_" + monthStr + "_" + dayStr + "_" + secondsStr
-	return m
+	return yearStr + "_" + monthStr + "_" + dayStr + "_" + secondsStr
 }
 
 func (m *Migration) Up(f migrationStepFunc) *Migration {
+	m.upFunc = withContext(f)
+	return m
+}
+
+func (m *Migration) UpContext(f ContextStepFunc) *Migration {
 	m.upFunc = f
 	return m
 }
 
 func (m *Migration) Down(f migrationStepFunc) *Migration {
+	m.downFunc = withContext(f)
+	return m
+}
+
+func (m *Migration) DownContext(f ContextStepFunc) *Migration {
 	m.downFunc = f
 	return m
 }
 
 func (m *Migration) PostUp(f migrationStepFunc) *Migration {
+	m.postUpFunc = withContext(f)
+	return m
+}
+
+func (m *Migration) PostUpContext(f ContextStepFunc) *Migration {
 	m.postUpFunc = f
 	return m
 }
 
 func (m *Migration) PostDown(f migrationStepFunc) *Migration {
+	m.postDownFunc = withContext(f)
+	return m
+}
+
+func (m *Migration) PostDownContext(f ContextStepFunc) *Migration {
 	m.postDownFunc = f
 	return m
 }
 
 func (m *Migration) Verify(f migrationStepFunc) *Migration {
+	m.verifyFunc = withContext(f)
+	return m
+}
+
+func (m *Migration) VerifyContext(f ContextStepFunc) *Migration {
 	m.verifyFunc = f
 	return m
 }
 
+// Timeout limits how long each step of the migration may run. A step that runs longer fails with a *TimeoutError
+// and the migration is rolled back as for any other failure. Steps should watch their context so they actually stop;
+// the rollback waits up to 10 seconds for one that doesn't, then starts while it is still running.
+func (m *Migration) Timeout(d time.Duration) *Migration {
+	m.timeout = d
+	return m
+}
+
+// UpTimeout limits how long the pre-deploy up step may run, overriding Timeout.
+func (m *Migration) UpTimeout(d time.Duration) *Migration {
+	m.upTimeout = d
+	return m
+}
+
+// DownTimeout limits how long the pre-deploy down step may run, overriding Timeout.
+func (m *Migration) DownTimeout(d time.Duration) *Migration {
+	m.downTimeout = d
+	return m
+}
+
+// PostUpTimeout limits how long the post-deploy up step may run, overriding Timeout.
+func (m *Migration) PostUpTimeout(d time.Duration) *Migration {
+	m.postUpTimeout = d
+	return m
+}
+
+// PostDownTimeout limits how long the post-deploy down step may run, overriding Timeout.
+func (m *Migration) PostDownTimeout(d time.Duration) *Migration {
+	m.postDownTimeout = d
+	return m
+}
+
+// VerifyTimeout limits how long the verification may run, overriding Timeout.
+func (m *Migration) VerifyTimeout(d time.Duration) *Migration {
+	m.verifyTimeout = d
+	return m
+}
+
+// AllowOutOfOrder lets the migration run even though newer migrations have already been applied, such as a hotfix
+// backported to an older version.
+func (m *Migration) AllowOutOfOrder() *Migration {
+	m.allowOutOfOrder = true
+	return m
+}
+
+// stepTimeout returns the timeout for a step, or 0 if it has none.
+func (m *Migration) stepTimeout(s scope, d direction) time.Duration {
+	timeout := m.postDownTimeout
+	switch {
+	case s == scopePreMigration && d == directionUp:
+		timeout = m.upTimeout
+	case s == scopePreMigration:
+		timeout = m.downTimeout
+	case d == directionUp:
+		timeout = m.postUpTimeout
+	}
+	if timeout > 0 {
+		return timeout
+	}
+	return m.timeout
+}
+
+func (m *Migration) stepFunc(s scope, d direction) ContextStepFunc {
+	switch {
+	case s == scopePreMigration && d == directionUp:
+		return m.upFunc
+	case s == scopePreMigration:
+		return m.downFunc
+	case d == directionUp:
+		return m.postUpFunc
+	default:
+		return m.postDownFunc
+	}
+}
+
+func (m *Migration) setHasRun(s scope, hasRun bool) {
+	if s == scopePreMigration {
+		m.preHasRun = hasRun
+	} else {
+		m.postHasRun = hasRun
+	}
+}
+
 func (m *Migration) Output(s string) {
+	if m.migrator != nil {
+		m.migrator.output().migrationOutput(m, s)
+		return
+	}
 	fmt.Println("[" + m.FormattedNumber + "] " + s)
 }
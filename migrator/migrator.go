@@ -1,19 +1,53 @@
 package migrator
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 type Migrator struct {
 	Migrations SortableMigrations
 	DbDriver   DbDriver
+
+	preMigration     func()
+	postMigration    func()
+	postFailure      func()
+	transactionScope TransactionScope
+
+	lockRenewInterval time.Duration
+	releaseLock       func()
+
+	retryAttempts int
+	retryBackoff  time.Duration
+
+	checksumPolicy        Policy
+	outOfOrderPolicy      Policy
+	unknownVersionsPolicy Policy
+	missingDownPolicy     Policy
+
+	// report collects the steps of the run in progress.
+	report *runReport
+	out    *output
+
+	// stepLogger is set on the copy of the Migrator handed to a step function.
+	stepLogger *slog.Logger
+	progress   *stepProgress
+	// checkpointPrefix scopes checkpoints to the sub-step running.
+	checkpointPrefix string
 }
 
+// TransactionScope controls how often the PreMigration, PostMigration and PostFailure hooks are called.
+type TransactionScope string
+
 type direction string
 type scope string
 
@@ -23,17 +57,48 @@ const (
 
 	directionUp   direction = "up"
 	directionDown           = "down"
+
+	// TransactionPerMigration calls the hooks around every migration step, so each step and its version
+	// bookkeeping is committed or rolled back on its own. This is the default.
+	TransactionPerMigration TransactionScope = "migration"
+	// TransactionPerBatch calls the hooks once around the whole run, so a failure anywhere rolls back every step
+	// that ran in it.
+	TransactionPerBatch TransactionScope = "batch"
 )
 
 var (
 	options = &UpDownOptions{
-		PreDeployOnly:  flag.Bool("pre", false, "Run Pre-deploy scripts only (default is all)"),
-		PostDeployOnly: flag.Bool("post", false, "Run Post-deploy scripts only (default is all)"),
-		Version:        flag.String("version", "", "Run up only on this version"),
-		Force:          flag.Bool("force", false, "Force the migration to run, even if it has already run successfully"),
+		PreDeployOnly:    flag.Bool("pre", false, "Run Pre-deploy scripts only (default is all)"),
+		PostDeployOnly:   flag.Bool("post", false, "Run Post-deploy scripts only (default is all)"),
+		Version:          flag.String("version", "", "Run up only on this version"),
+		Force:            flag.Bool("force", false, "Force the migration to run, even if it has already run successfully"),
+		Steps:            flag.Int("steps", 0, "Run down the last N applied migrations, newest first"),
+		ToVersion:        flag.String("to", "", "Run up to and including this version, or down to (but not including) this version"),
+		DryRun:           flag.Bool("dry-run", false, "Print the steps that would run without running them or changing the database"),
+		ResetCheckpoints: flag.Bool("reset-checkpoints", false, "With -force, start the steps over instead of resuming them from their checkpoints"),
+		Parallel:         flag.Int("parallel", 0, "With -post, run the post-deploy steps of up to N migrations marked Parallelizable at the same time"),
 	}
-	up   = flag.Bool("up", false, "Run up scripts")
-	down = flag.Bool("down", false, "Run down scripts")
+	up       = flag.Bool("up", false, "Run up scripts")
+	down     = flag.Bool("down", false, "Run down scripts")
+	status   = flag.Bool("status", false, "Print the pre and post-deploy state of every migration")
+	redo     = flag.Bool("redo", false, "Run a migration down and then up again")
+	unlock   = flag.Bool("unlock", false, "Break a stale migration lock left behind by a migrator that didn't exit cleanly")
+	history  = flag.Bool("history", false, "Print the recorded history of migration steps, optionally for -version")
+	baseline = flag.Bool("baseline", false, "Record every migration up to and including -version as applied without running it")
+	verify   = flag.Bool("verify", false, "Run the verification of every applied migration, or of -version, without changing the database")
+
+	historyLimit = flag.Int("limit", 0, "Only print the last N history entries")
+
+	lockTimeout  = flag.Duration("lock-timeout", time.Minute, "How long to wait for another migrator to release the migration lock")
+	outputFormat = flag.String("output", string(OutputText), "Output format, text or json")
+
+	retryAttempts = flag.Int("retry-attempts", 0, "How many times to attempt a step that fails with a retryable error, for migrations without their own Retry")
+	retryBackoff  = flag.Duration("retry-backoff", time.Second, "How long to wait before retrying a step, doubled for each retry after the first")
+
+	checksumPolicy        = flag.String("checksum-policy", "", "What to do when an applied migration has been modified since: error, warn or allow (default warn)")
+	outOfOrderPolicy      = flag.String("out-of-order", "", "What to do when a pending migration is older than the newest applied one: error, warn or allow (default warn)")
+	unknownVersionsPolicy = flag.String("unknown-versions", "", "What to do when applied versions aren't registered: error, warn or allow (default warn)")
+	missingDownPolicy     = flag.String("missing-down", "", "What to do when running down a step without a down function: error, warn or allow (default warn)")
 )
 
 type DbDriver interface {
@@ -44,109 +109,384 @@ type DbDriver interface {
 
 func NewMigrator() *Migrator {
 
-	return &Migrator{}
+	return &Migrator{
+		transactionScope:      TransactionPerMigration,
+		checksumPolicy:        PolicyWarn,
+		outOfOrderPolicy:      PolicyWarn,
+		unknownVersionsPolicy: PolicyWarn,
+		missingDownPolicy:     PolicyWarn,
+	}
+}
+
+// PreMigration sets a hook that is called before a migration step runs, typically to start a transaction.
+func (m *Migrator) PreMigration(f func()) {
+	m.preMigration = f
+}
+
+// PostMigration sets a hook that is called after a migration step succeeds, typically to commit the transaction.
+func (m *Migrator) PostMigration(f func()) {
+	m.postMigration = f
 }
 
-func (m *Migrator) PreMigration(f func())  {}
-func (m *Migrator) PostMigration(f func()) {}
-func (m *Migrator) PostFailure(f func())   {}
+// PostFailure sets a hook that is called after a migration step fails, typically to roll back the transaction.
+func (m *Migrator) PostFailure(f func()) {
+	m.postFailure = f
+}
 
+// SetTransactionScope sets whether the transaction hooks wrap each migration step or the whole run.
+func (m *Migrator) SetTransactionScope(s TransactionScope) {
+	m.transactionScope = s
+}
+
+// Register adds a migration to the Migrator. Missing dependencies, dependency cycles and duplicate versions are
+// returned as a *DependencyError when the migrations run.
 func (m *Migrator) Register(mig *Migration) {
+	mig.migrator = m
 	m.Migrations = append(m.Migrations, mig)
 }
 
-// Run is responsible for running all pending migrations
+// Run is the entry point of the migrator binary. It runs the command given by the command line flags and exits
+// with a non-zero status if it fails. SIGINT and SIGTERM stop the run cleanly between steps, and a second one kills
+// it. Use Up, Down and friends to run migrations from your own code instead.
 func (m *Migrator) Run() {
 	flag.Parse()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// stop catching signals once the first arrives, so a second one can kill a step that doesn't stop.
+		<-ctx.Done()
+		stop()
+	}()
+	if m.out == nil && *outputFormat == string(OutputJSON) {
+		m.SetOutput(OutputJSON, os.Stdout)
+	}
+	opts := RunOptions{
+		PreDeployOnly:    *options.PreDeployOnly,
+		PostDeployOnly:   *options.PostDeployOnly,
+		Version:          *options.Version,
+		ToVersion:        *options.ToVersion,
+		Steps:            *options.Steps,
+		Force:            *options.Force,
+		DryRun:           *options.DryRun,
+		Parallel:         *options.Parallel,
+		ResetCheckpoints: *options.ResetCheckpoints,
+		LockTimeout:      *lockTimeout,
+	}
+
+	if *retryAttempts > 0 {
+		m.SetRetry(*retryAttempts, *retryBackoff)
+	}
+	if *checksumPolicy != "" {
+		m.SetChecksumPolicy(Policy(*checksumPolicy))
+	}
+	if *outOfOrderPolicy != "" {
+		m.SetOutOfOrderPolicy(Policy(*outOfOrderPolicy))
+	}
+	if *unknownVersionsPolicy != "" {
+		m.SetUnknownVersionsPolicy(Policy(*unknownVersionsPolicy))
+	}
+	if *missingDownPolicy != "" {
+		m.SetMissingDownPolicy(Policy(*missingDownPolicy))
+	}
+
+	start := time.Now()
+	var report *Report
 	var err error
-	sort.Sort(m.Migrations)
+	switch {
+	case *outputFormat != string(OutputText) && *outputFormat != string(OutputJSON):
+		err = &OptionsError{Message: "Unknown -output format " + *outputFormat + ", use text or json"}
+	case !m.checksumPolicy.valid():
+		err = &OptionsError{Message: "Unknown checksum policy " + string(m.checksumPolicy) + ", use error, warn or allow"}
+	case !m.outOfOrderPolicy.valid():
+		err = &OptionsError{Message: "Unknown out-of-order policy " + string(m.outOfOrderPolicy) + ", use error, warn or allow"}
+	case !m.unknownVersionsPolicy.valid():
+		err = &OptionsError{Message: "Unknown unknown-versions policy " + string(m.unknownVersionsPolicy) + ", use error, warn or allow"}
+	case !m.missingDownPolicy.valid():
+		err = &OptionsError{Message: "Unknown missing-down policy " + string(m.missingDownPolicy) + ", use error, warn or allow"}
+	case *status:
+		err = m.printStatus(os.Stdout)
+	case *history:
+		err = m.printHistory(os.Stdout, normalizeVersion(*options.Version), *historyLimit)
+	case *baseline:
+		report, err = m.Baseline(ctx, opts)
+	case *verify:
+		report, err = m.VerifyApplied(ctx, *options.Version)
+	case *unlock:
+		if err = m.BreakLock(); err == nil {
+			m.output().message("Migration lock released")
+		}
+	case *redo:
+		report, err = m.Redo(ctx, opts)
+	case *down && !*up:
+		report, err = m.Down(ctx, opts)
+	default:
+		report, err = m.Up(ctx, opts)
+	}
+
+	m.output().finishRun(report, err, time.Since(start))
+	if err != nil {
+		stop()
+		os.Exit(exitCode(err))
+	}
+}
+
+// Up runs all pending migrations, or the ones selected by opts, and reports the steps it ran. If a step fails the
+// migration is rolled back and a *StepError is returned.
+func (m *Migrator) Up(ctx context.Context, opts RunOptions) (*Report, error) {
+	o, err := opts.runOptions(false, false)
+	if err != nil {
+		return nil, err
+	}
+	return m.run(ctx, o)
+}
+
+// Down runs down the migrations selected by opts, newest first, and reports the steps it ran. One of Version,
+// ToVersion or Steps is required.
+func (m *Migrator) Down(ctx context.Context, opts RunOptions) (*Report, error) {
+	o, err := opts.runOptions(true, false)
+	if err != nil {
+		return nil, err
+	}
+	return m.run(ctx, o)
+}
 
-	runPre := true
-	runPost := true
-	if options.PreDeployOnly != nil && *options.PreDeployOnly {
-		runPost = false
+// Redo runs a migration down and then up again, for opts.Version or for the latest applied migration. Whatever the
+// transaction scope, the transaction hooks wrap the down and the up together, so if the up fails the PostFailure
+// hook rolls back the down too. Without hooks a failed up is rolled back by its down steps, as in Up, leaving the
+// migration run down.
+func (m *Migrator) Redo(ctx context.Context, opts RunOptions) (*Report, error) {
+	o, err := opts.runOptions(false, true)
+	if err != nil {
+		return nil, err
 	}
-	if options.PostDeployOnly != nil && *options.PostDeployOnly {
-		runPre = false
+	return m.run(ctx, o)
+}
+
+func (m *Migrator) run(ctx context.Context, opts *runOptions) (*Report, error) {
+	report := &Report{DryRun: opts.dryRun}
+	if err := m.sortMigrations(); err != nil {
+		return report, err
 	}
-	if !runPre && !runPost {
-		fmt.Println("Cannot use both -pre and -post exclusivity flags at the same time. If you want to run both (which is the default), don't supply -pre and -post arguments")
-		os.Exit(2)
+	if opts.parallel > 1 && m.transactionScope == TransactionPerBatch {
+		return report, &OptionsError{Message: "Cannot use -parallel when the transaction hooks wrap the whole batch"}
 	}
-	version := ""
-	if options.Version != nil {
-		version = strings.Replace(*options.Version, "-", "", -1)
-		version = strings.Replace(version, `"`, "", -1)
+	if opts.parallel > 1 && (m.preMigration != nil || m.postMigration != nil || m.postFailure != nil) {
+		return report, &OptionsError{Message: "Cannot use -parallel with transaction hooks, they can only wrap one step at a time"}
 	}
-	force := options.Force != nil && *options.Force
-	if force && version == "" {
-		fmt.Println("Cannot use -force without -version")
-		os.Exit(3)
+	if m.retryAttempts > 1 && m.transactionScope == TransactionPerBatch {
+		return report, &OptionsError{Message: "Cannot retry steps when the transaction hooks wrap the whole batch"}
 	}
-	if down != nil && *down && version == "" {
-		fmt.Println("Cannot run down migrations without a version specified")
-		os.Exit(6)
+
+	if opts.to != "" {
+		if opts.toIndex = m.indexOf(opts.to); opts.toIndex < 0 {
+			return report, &OptionsError{Message: "Cannot migrate to version " + opts.to + ", it is not a registered migration"}
+		}
 	}
 
-	m.setRunStates()
+	if !opts.dryRun {
+		var err error
+		if ctx, err = m.lock(ctx, opts.lockTimeout); err != nil {
+			return report, err
+		}
+		defer m.release()
+	}
 
-	if (up != nil && *up) || down == nil || !*down {
-		for _, mig := range m.Migrations {
-			if version != "" && strconv.FormatInt(mig.OrderingNumber, 10) != version {
-				continue
-			}
-			if runPre {
-				if !mig.preHasRun || force {
-					if err = m.runFunctionHook(mig, mig.upFunc, directionUp, scopePreMigration, mig.OrderingNumber); err != nil {
-						m.runFunctionHook(mig, mig.downFunc, directionDown, scopePreMigration, mig.OrderingNumber)
-						os.Exit(4)
-					}
-				}
-			}
-			if runPost {
-				if !mig.postHasRun || force {
-					if err = m.runFunctionHook(mig, mig.postUpFunc, directionUp, scopePostMigration, mig.OrderingNumber); err != nil {
-						m.runFunctionHook(mig, mig.postDownFunc, directionDown, scopePostMigration, mig.OrderingNumber)
-						if runPre {
-							m.runFunctionHook(mig, mig.downFunc, directionDown, scopePreMigration, mig.OrderingNumber)
-						}
-						os.Exit(4)
-					}
-				}
-			}
+	unregistered, err := m.setRunStates()
+	if err != nil {
+		return report, err
+	}
+	if opts.steps > 0 {
+		if err := m.selectLastApplied(opts, unregistered); err != nil {
+			return report, err
 		}
-	} else { // run down
-		for i := len(m.Migrations) - 1; i >= 0; i-- {
-			mig := m.Migrations[i]
-			if version != "" && strconv.FormatInt(mig.OrderingNumber, 10) != version {
-				continue
-			}
-			if runPost {
-				if mig.postHasRun || force {
-					if err = m.runFunctionHook(mig, mig.postDownFunc, directionDown, scopePostMigration, mig.OrderingNumber); err != nil {
-						os.Exit(4)
-					}
-				}
+	}
+	forced := ""
+	if opts.force || opts.redo {
+		forced = opts.version
+	}
+	if err := m.checkChecksums(forced); err != nil {
+		return report, err
+	}
+	if err := m.checkUnknownVersions(unregistered); err != nil {
+		return report, err
+	}
+
+	m.report = &runReport{Report: report}
+	defer func() { m.report = nil }()
+
+	if opts.redo {
+		return report, m.redo(ctx, opts, unregistered)
+	}
+
+	steps := m.plan(opts)
+	if !opts.force {
+		if err := m.checkOutOfOrder(steps, unregistered); err != nil {
+			return report, err
+		}
+	}
+	if err := m.checkReversible(steps, opts.force); err != nil {
+		return report, err
+	}
+	if opts.resetCheckpoints && !opts.dryRun {
+		if err := m.resetCheckpoints(steps); err != nil {
+			return report, err
+		}
+	}
+	if opts.dryRun {
+		report.addPlan(steps)
+		return report, nil
+	}
+
+	if m.transactionScope == TransactionPerBatch {
+		callHook(m.preMigration)
+	}
+	if err := m.execute(ctx, steps, opts); err != nil {
+		return report, err
+	}
+	if m.transactionScope == TransactionPerBatch {
+		callHook(m.postMigration)
+	}
+	return report, nil
+}
+
+// redo runs a migration down and then up again, for -version or for the latest applied migration.
+func (m *Migrator) redo(ctx context.Context, opts *runOptions, unregistered []*Migration) error {
+	if opts.version == "" {
+		latest := *opts
+		latest.steps = 1
+		if err := m.selectLastApplied(&latest, unregistered); err != nil {
+			return err
+		}
+		for version := range latest.lastApplied {
+			opts.version = strconv.FormatInt(version, 10)
+		}
+	}
+	i := m.indexOf(opts.version)
+	if i < 0 {
+		return &OptionsError{Message: "Cannot redo version " + opts.version + ", it is not a registered migration"}
+	}
+	mig := m.Migrations[i]
+
+	downOpts, upOpts := *opts, *opts
+	downOpts.down, downOpts.force = true, false
+	upOpts.down, upOpts.force = false, true
+	downSteps := m.plan(&downOpts)
+	upSteps := m.plan(&upOpts)
+	if err := m.checkReversible(downSteps, opts.force); err != nil {
+		return err
+	}
+	if opts.resetCheckpoints && !opts.dryRun {
+		if err := m.resetCheckpoints(append(downSteps, upSteps...)); err != nil {
+			return err
+		}
+	}
+
+	if opts.dryRun {
+		m.report.addPlan(append(downSteps, upSteps...))
+		return nil
+	}
+
+	// with hooks the down and up are one batch, so a failed up rolls back the down with it.
+	if m.preMigration != nil || m.postMigration != nil || m.postFailure != nil {
+		transactionScope := m.transactionScope
+		m.transactionScope = TransactionPerBatch
+		defer func() { m.transactionScope = transactionScope }()
+	}
+
+	callHook(m.preMigration)
+	if len(downSteps) == 0 {
+		mig.Output("Redo: migration has not been applied, nothing to run down")
+	} else {
+		mig.Output("Redo: running migration down (" + mig.Name + ")")
+		if err := m.execute(ctx, downSteps, &downOpts); err != nil {
+			return err
+		}
+	}
+	mig.Output("Redo: running migration up (" + mig.Name + ")")
+	if err := m.execute(ctx, upSteps, &upOpts); err != nil {
+		return err
+	}
+	callHook(m.postMigration)
+	mig.Output("Redo: done")
+	return nil
+}
+
+// execute runs the planned steps in order. If one of them fails, it rolls back and returns a *StepError. If ctx is
+// canceled it stops before the next step and returns a *CanceledError, or the *DriverError if the migration lock
+// couldn't be renewed, leaving the steps that completed in place.
+// With -parallel, consecutive post-deploy steps of Parallelizable migrations run concurrently instead.
+func (m *Migrator) execute(ctx context.Context, steps []migrationStep, opts *runOptions) error {
+	for i := 0; i < len(steps); {
+		step := steps[i]
+		if ctx.Err() != nil {
+			step.mig.Output(fmt.Sprintf("Stopping before the remaining %d step(s): %v", len(steps)-i, context.Cause(ctx)))
+			// the steps that finished stay applied, so commit them along with the batch.
+			if m.transactionScope == TransactionPerBatch {
+				callHook(m.postMigration)
 			}
-			if runPre {
-				if mig.preHasRun || force {
-					if err = m.runFunctionHook(mig, mig.downFunc, directionDown, scopePreMigration, mig.OrderingNumber); err != nil {
-						os.Exit(4)
-					}
+			return stopError(ctx)
+		}
+
+		if opts.parallel > 1 {
+			if units, n := parallelUnits(steps[i:]); len(units) > 1 {
+				if err := m.executeParallel(ctx, units, opts); err != nil {
+					return err
 				}
+				i += n
+				continue
 			}
 		}
+		if err := m.runPlannedStep(ctx, step); err != nil {
+			return m.fail(ctx, step, opts, err)
+		}
+		i++
+	}
+	return nil
+}
+
+// runPlannedStep runs a single planned step.
+func (m *Migrator) runPlannedStep(ctx context.Context, step migrationStep) error {
+	if step.verify {
+		return m.runVerify(ctx, step.mig)
+	}
+	return m.runFunctionHook(ctx, step.mig, step.mig.stepFunc(step.scope, step.direction), step.direction, step.scope, step.mig.OrderingNumber)
+}
+
+// indexOf returns the position of version in the sorted migrations, or -1 if it is not registered.
+func (m *Migrator) indexOf(version string) int {
+	for i, mig := range m.Migrations {
+		if strconv.FormatInt(mig.OrderingNumber, 10) == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// fail undoes what it can after a failed step. In a per-migration transaction scope the failed step has already
+// been rolled back, so the migration's down steps are run to undo the rest of it. In a batch transaction scope the
+// PostFailure hook rolls back the whole run instead.
+func (m *Migrator) fail(ctx context.Context, step migrationStep, opts *runOptions, err error) *StepError {
+	switch {
+	case m.transactionScope == TransactionPerBatch:
+		callHook(m.postFailure)
+	case !step.verify && step.mig.resumable():
+		step.mig.Output("Not rolling back, the step saved a checkpoint and resumes from it on the next run")
+	default:
+		m.rollBack(ctx, step, opts)
 	}
+	return newStepError(step, err)
 }
 
-func (m *Migrator) setRunStates() {
+// setRunStates marks which registered migrations have already run, and returns a migration for every version found
+// in the database that is not registered in this binary.
+func (m *Migrator) setRunStates() ([]*Migration, error) {
 	preMigrationsRunInts, err := m.DbDriver.GetAllRunVersions(string(scopePreMigration))
 	if err != nil {
-		panic("Error getting run versions: " + err.Error())
+		return nil, &DriverError{Op: "get run versions", Err: err}
 	}
 	postMigrationsRunInts, err := m.DbDriver.GetAllRunVersions(string(scopePostMigration))
 	if err != nil {
-		panic("Error getting run versions: " + err.Error())
+		return nil, &DriverError{Op: "get run versions", Err: err}
 	}
 	preMigrationsRun := buildMapFromIntArray(preMigrationsRunInts)
 	postMigrationsRun := buildMapFromIntArray(postMigrationsRunInts)
@@ -154,14 +494,62 @@ func (m *Migrator) setRunStates() {
 	for i := range m.Migrations {
 		_, m.Migrations[i].preHasRun = preMigrationsRun[m.Migrations[i].OrderingNumber]
 		_, m.Migrations[i].postHasRun = postMigrationsRun[m.Migrations[i].OrderingNumber]
+		delete(preMigrationsRun, m.Migrations[i].OrderingNumber)
+		delete(postMigrationsRun, m.Migrations[i].OrderingNumber)
+	}
+
+	unregistered := SortableMigrations{}
+	for version := range preMigrationsRun {
+		unregistered = append(unregistered, NewMigration(version, ""))
 	}
+	for version := range postMigrationsRun {
+		if _, ok := preMigrationsRun[version]; !ok {
+			unregistered = append(unregistered, NewMigration(version, ""))
+		}
+	}
+	for _, mig := range unregistered {
+		_, mig.preHasRun = preMigrationsRun[mig.OrderingNumber]
+		_, mig.postHasRun = postMigrationsRun[mig.OrderingNumber]
+	}
+	sort.Sort(unregistered)
+	return unregistered, nil
+}
+
+// runFunctionHook runs a single migration step and records it in the database, wrapped in the transaction hooks
+// unless they are wrapping the whole batch.
+func (m *Migrator) runFunctionHook(ctx context.Context, mig *Migration, f ContextStepFunc, direction direction, scope scope, version int64) error {
+	m.startStep(ctx, mig, string(scope), string(direction))
+	start := time.Now()
+	var err error
+	if m.transactionScope == TransactionPerBatch {
+		err = m.runStep(ctx, mig, f, direction, scope, version)
+	} else {
+		attempts, backoff := m.retryPolicy(mig)
+		for attempt := 1; ; attempt++ {
+			callHook(m.preMigration)
+			if err = m.runStep(ctx, mig, f, direction, scope, version); err != nil {
+				callHook(m.postFailure)
+			} else {
+				callHook(m.postMigration)
+			}
+			if err == nil || attempt >= attempts || !m.isRetryable(err) {
+				break
+			}
+			if !m.waitToRetry(ctx, mig, string(scope)+"-"+string(direction), attempt, attempts, backoff, err) {
+				break
+			}
+			backoff *= 2
+		}
+	}
+	m.finishStep(ctx, mig, string(scope), string(direction), start, err)
+	return err
 }
 
-func (m *Migrator) runFunctionHook(mig *Migration, f migrationStepFunc, direction direction, scope scope, version int64) error {
+func (m *Migrator) runStep(ctx context.Context, mig *Migration, f ContextStepFunc, direction direction, scope scope, version int64) error {
 	if f != nil {
 		mig.Output("Running " + string(scope) + "-" + string(direction) + " migration (" + mig.Name + ")")
 
-		if err := f(m); err != nil {
+		if err := m.forStep(mig, string(scope), string(direction)).callStep(ctx, f, mig.stepTimeout(scope, direction)); err != nil {
 			mig.Output(fmt.Sprintf("Failed to run %s-%s migration: %v", scope, direction, err))
 			return err
 		}
@@ -169,32 +557,99 @@ func (m *Migrator) runFunctionHook(mig *Migration, f migrationStepFunc, directio
 	if direction == directionUp {
 		if err := m.DbDriver.InsertVersion(string(scope), version); err != nil {
 			mig.Output(fmt.Sprintf("Error Inserting version %d scope %s into db: %s", version, scope, err.Error()))
-			return err
+			return &DriverError{Op: "insert version", Err: err}
 		}
 	} else {
 		if err := m.DbDriver.RemoveVersion(string(scope), version); err != nil {
 			mig.Output(fmt.Sprintf("Error Removeing version %d scope %s from db: %s", version, scope, err.Error()))
-			return err
+			return &DriverError{Op: "remove version", Err: err}
 		}
 	}
+	if direction == directionUp {
+		m.recordChecksum(mig)
+	}
+	if err := m.clearCompletedCheckpoints(mig, scope, direction); err != nil {
+		m.output().warn("could not clear the checkpoints of migration " + mig.FormattedNumber + ": " + err.Error())
+	}
+	mig.setHasRun(scope, direction == directionUp)
 	return nil
 }
 
+// runVerify runs the migration's verify function, if it has one, and reports the outcome.
+func (m *Migrator) runVerify(ctx context.Context, mig *Migration) error {
+	if mig.verifyFunc == nil {
+		return nil
+	}
+	m.startStep(ctx, mig, "", "verify")
+	start := time.Now()
+	mig.Output("Verifying migration (" + mig.Name + ")")
+	timeout := mig.verifyTimeout
+	if timeout <= 0 {
+		timeout = mig.timeout
+	}
+	err := m.forStep(mig, "", "verify").callStep(ctx, mig.verifyFunc, timeout)
+	if err != nil {
+		mig.Output(fmt.Sprintf("Verification failed: %v", err))
+	} else {
+		mig.Output("Verification passed")
+	}
+	m.finishStep(ctx, mig, "", "verify", start, err)
+	return err
+}
+
+// stepGracePeriod is how long a step that timed out has to return once its context is canceled, before it is
+// rolled back while still running.
+const stepGracePeriod = 10 * time.Second
+
+// callStep calls a step function, failing it with a *TimeoutError if it runs longer than timeout. A step that times
+// out is waited on for up to stepGracePeriod, so it isn't rolled back while it's still writing.
+func (m *Migrator) callStep(ctx context.Context, f ContextStepFunc, timeout time.Duration) error {
+	if timeout <= 0 {
+		return f(ctx, m)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		result <- f(ctx, m)
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			return &TimeoutError{Timeout: timeout}
+		}
+		return err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			select {
+			case <-result:
+			case <-time.After(stepGracePeriod):
+				m.output().warn(fmt.Sprintf("the step didn't stop within %s of timing out, it may still be running", stepGracePeriod))
+			}
+			return &TimeoutError{Timeout: timeout}
+		}
+		// canceled from outside, give the step the chance to stop cleanly.
+		return <-result
+	}
+}
+
 func (m *Migrator) TestMigrationStep(mig *Migration, scopeStr string, directionStr string) error {
 	switch scope(scopeStr) {
 	case scopePreMigration:
 		switch direction(directionStr) {
 		case directionUp:
-			return mig.upFunc(m)
+			return mig.upFunc(context.Background(), m)
 		case directionDown:
-			return mig.downFunc(m)
+			return mig.downFunc(context.Background(), m)
 		}
 	case scopePostMigration:
 		switch direction(directionStr) {
 		case directionUp:
-			return mig.postUpFunc(m)
+			return mig.postUpFunc(context.Background(), m)
 		case directionDown:
-			return mig.postDownFunc(m)
+			return mig.postDownFunc(context.Background(), m)
 		}
 	default:
 		panic("bad scope: " + scopeStr)
@@ -216,6 +671,20 @@ func (m SortableMigrations) Swap(a, b int) {
 	m[a], m[b] = m[b], m[a]
 }
 
+// normalizeVersion accepts a version as printed (2017_10_25_00001 or 2017-10-25-00001) or as registered
+// (2017102500001), and returns it in its registered form.
+func normalizeVersion(version string) string {
+	version = strings.Replace(version, "-", "", -1)
+	version = strings.Replace(version, "_", "", -1)
+	return strings.Replace(version, `"`, "", -1)
+}
+
+func callHook(f func()) {
+	if f != nil {
+		f()
+	}
+}
+
 func buildMapFromIntArray(ints []int64) map[int64]struct{} {
 	result := map[int64]struct{}{}
 	for i := range ints {
@@ -0,0 +1,1298 @@
+package migrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type memoryDriver struct {
+	mu       sync.Mutex
+	versions map[string]map[int64]struct{}
+}
+
+func newMemoryDriver() *memoryDriver {
+	return &memoryDriver{versions: map[string]map[int64]struct{}{
+		string(scopePreMigration):  {},
+		string(scopePostMigration): {},
+	}}
+}
+
+func (d *memoryDriver) GetAllRunVersions(scope string) ([]int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := []int64{}
+	for v := range d.versions[scope] {
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+func (d *memoryDriver) InsertVersion(scope string, version int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.versions[scope][version] = struct{}{}
+	return nil
+}
+
+func (d *memoryDriver) RemoveVersion(scope string, version int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.versions[scope], version)
+	return nil
+}
+
+func newTestMigrator(calls *[]string) *Migrator {
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	m.PreMigration(func() { *calls = append(*calls, "begin") })
+	m.PostMigration(func() { *calls = append(*calls, "commit") })
+	m.PostFailure(func() { *calls = append(*calls, "rollback") })
+	return m
+}
+
+func TestTransactionHooks(t *testing.T) {
+	calls := []string{}
+	m := newTestMigrator(&calls)
+	mig := NewMigration(2017102500001, "test")
+
+	ok := func(ctx context.Context, m *Migrator) error { calls = append(calls, "step"); return nil }
+	fail := func(ctx context.Context, m *Migrator) error { calls = append(calls, "step"); return errors.New("boom") }
+
+	if err := m.runFunctionHook(context.Background(), mig, ok, directionUp, scopePreMigration, mig.OrderingNumber); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.runFunctionHook(context.Background(), mig, fail, directionUp, scopePostMigration, mig.OrderingNumber); err == nil {
+		t.Fatal("expected the failing step to return an error")
+	}
+
+	expected := []string{"begin", "step", "commit", "begin", "step", "rollback"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected hook calls %v, but they were %v", expected, calls)
+	}
+	if _, ok := m.DbDriver.(*memoryDriver).versions[scopePostMigration][mig.OrderingNumber]; ok {
+		t.Error("expected the failed step not to be recorded")
+	}
+}
+
+func TestTransactionHooksPerBatch(t *testing.T) {
+	calls := []string{}
+	m := newTestMigrator(&calls)
+	m.SetTransactionScope(TransactionPerBatch)
+	mig := NewMigration(2017102500001, "test")
+
+	if err := m.runFunctionHook(context.Background(), mig, nil, directionUp, scopePreMigration, mig.OrderingNumber); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("Expected no hook calls around a single step in batch scope, but there were %v", calls)
+	}
+}
+
+func TestRunVerify(t *testing.T) {
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	mig := NewMigration(2017102500001, "test").Verify(func(m *Migrator) error {
+		return errors.New("unexpected schema")
+	})
+
+	if err := m.runFunctionHook(context.Background(), mig, nil, directionUp, scopePreMigration, mig.OrderingNumber); err != nil {
+		t.Fatal(err)
+	}
+	if !mig.preHasRun {
+		t.Error("expected the pre-deploy step to be marked as run")
+	}
+	if err := m.runVerify(context.Background(), mig); err == nil {
+		t.Error("expected verification to fail")
+	}
+}
+
+func TestUpRollsBackFailedVerify(t *testing.T) {
+	driver := newMemoryDriver()
+	m := NewMigrator()
+	m.DbDriver = driver
+	m.SetOutput(OutputText, &bytes.Buffer{})
+	calls := []string{}
+	step := func(name string) migrationStepFunc {
+		return func(m *Migrator) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	backfilled := false
+	m.Register(NewMigration(2017102500001, "backfill_users").
+		Up(step("up")).
+		PostUp(func(m *Migrator) error {
+			backfilled = true
+			return nil
+		}).
+		PostDown(step("post-down")).
+		Down(step("down")).
+		Verify(func(m *Migrator) error {
+			if !backfilled {
+				return errors.New("users not backfilled")
+			}
+			return errors.New("unexpected schema")
+		}))
+
+	if _, err := m.Up(context.Background(), RunOptions{PreDeployOnly: true}); err != nil {
+		t.Fatalf("Expected a -pre run not to verify post-deploy work, but got %v", err)
+	}
+	if pre, _ := driver.GetAllRunVersions(string(scopePreMigration)); len(pre) != 1 {
+		t.Errorf("Expected the pre-deploy step to stay applied, but pre versions are %v", pre)
+	}
+
+	_, err := m.Up(context.Background(), RunOptions{})
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) || stepErr.Direction != "verify" {
+		t.Fatalf("Expected the verification to fail, but got %v", err)
+	}
+	if expected := []string{"up", "post-down", "down"}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls %v, but they were %v", expected, calls)
+	}
+	for _, scope := range []scope{scopePreMigration, scopePostMigration} {
+		if versions, _ := driver.GetAllRunVersions(string(scope)); len(versions) != 0 {
+			t.Errorf("Expected the %s version to be removed, but versions are %v", scope, versions)
+		}
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	m.Register(NewMigration(2017102500001, "add_users"))
+	m.Register(NewMigration(2017102600002, "add_posts"))
+	m.DbDriver.InsertVersion(string(scopePreMigration), 2017102500001)
+	m.DbDriver.InsertVersion(string(scopePostMigration), 2017102500001)
+	m.DbDriver.InsertVersion(string(scopePreMigration), 2017102600002)
+	m.DbDriver.InsertVersion(string(scopePreMigration), 2017090100003)
+
+	buf := &bytes.Buffer{}
+	if err := m.printStatus(buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	expected := [][]string{
+		{"VERSION", "NAME", "PRE-DEPLOY", "POST-DEPLOY"},
+		{"2017_10_25_00001", "add_users", "applied", "applied"},
+		{"2017_10_26_00002", "add_posts", "applied", "pending"},
+		{"2017_09_01_00003", "(not", "registered)", "applied", "pending"},
+	}
+	for i, fields := range expected {
+		if i >= len(lines) || !reflect.DeepEqual(strings.Fields(lines[i]), fields) {
+			t.Fatalf("Expected status line %d to be %v, but the output was:\n%s", i, fields, buf.String())
+		}
+	}
+	if !strings.Contains(buf.String(), "WARNING: 1 version(s)") {
+		t.Errorf("Expected a warning about the unregistered version, but the output was:\n%s", buf.String())
+	}
+}
+
+func TestPlan(t *testing.T) {
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	verify := func(m *Migrator) error { return nil }
+	m.Register(NewMigration(2017102600002, "add_posts").Verify(verify))
+	m.Register(NewMigration(2017102500001, "add_users"))
+	m.DbDriver.InsertVersion(string(scopePreMigration), 2017102500001)
+	sort.Sort(m.Migrations)
+	m.setRunStates()
+
+	describe := func(steps []migrationStep) []string {
+		result := []string{}
+		for _, step := range steps {
+			if step.verify {
+				result = append(result, step.mig.Name+" verify")
+			} else {
+				result = append(result, step.mig.Name+" "+string(step.scope)+"-"+string(step.direction))
+			}
+		}
+		return result
+	}
+
+	up := describe(m.plan(&runOptions{runPre: true, runPost: true}))
+	expected := []string{"add_users post-up", "add_posts pre-up", "add_posts post-up", "add_posts verify"}
+	if !reflect.DeepEqual(up, expected) {
+		t.Errorf("Expected up plan %v, but it was %v", expected, up)
+	}
+
+	down := describe(m.plan(&runOptions{runPre: true, runPost: true, down: true, version: "2017102500001"}))
+	expected = []string{"add_users pre-down"}
+	if !reflect.DeepEqual(down, expected) {
+		t.Errorf("Expected down plan %v, but it was %v", expected, down)
+	}
+}
+
+func TestPlanToVersion(t *testing.T) {
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	for _, version := range []int64{2017102500001, 2017102600002, 2017102700003} {
+		m.Register(NewMigration(version, strconv.FormatInt(version, 10)))
+	}
+	m.DbDriver.InsertVersion(string(scopePreMigration), 2017102500001)
+	m.DbDriver.InsertVersion(string(scopePreMigration), 2017102600002)
+	m.DbDriver.InsertVersion(string(scopePreMigration), 2017102700003)
+	m.setRunStates()
+
+	steps := m.plan(&runOptions{runPre: true, runPost: true, to: "2017102600002", toIndex: m.indexOf("2017102600002")})
+	if len(steps) != 2 || steps[0].mig.OrderingNumber != 2017102500001 || steps[1].mig.OrderingNumber != 2017102600002 {
+		t.Errorf("Expected -to to run the post-deploy steps of the first two migrations, but the plan was %v", steps)
+	}
+
+	steps = m.plan(&runOptions{runPre: true, runPost: true, down: true, to: "2017102500001", toIndex: m.indexOf("2017102500001")})
+	if len(steps) != 2 || steps[0].mig.OrderingNumber != 2017102700003 || steps[1].mig.OrderingNumber != 2017102600002 {
+		t.Errorf("Expected down -to to roll back the two newest migrations newest first, but the plan was %v", steps)
+	}
+}
+
+func TestSelectLastApplied(t *testing.T) {
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	for _, version := range []int64{2017102500001, 2017102600002, 2017102700003} {
+		m.Register(NewMigration(version, strconv.FormatInt(version, 10)))
+	}
+	m.DbDriver.InsertVersion(string(scopePreMigration), 2017102500001)
+	m.DbDriver.InsertVersion(string(scopePreMigration), 2017102600002)
+	m.DbDriver.InsertVersion(string(scopePostMigration), 2017102600002)
+
+	opts := &runOptions{runPre: true, runPost: true, down: true, steps: 1}
+	unregistered, _ := m.setRunStates()
+	if err := m.selectLastApplied(opts, unregistered); err != nil {
+		t.Fatal(err)
+	}
+	steps := m.plan(opts)
+	if len(steps) != 2 || steps[0].scope != scopePostMigration || steps[1].scope != scopePreMigration || steps[0].mig.OrderingNumber != 2017102600002 {
+		t.Errorf("Expected -steps 1 to run the newest applied migration down, but the plan was %v", steps)
+	}
+
+	m.DbDriver.InsertVersion(string(scopePreMigration), 2017110100004)
+	opts = &runOptions{runPre: true, runPost: true, down: true, steps: 1}
+	unregistered, _ = m.setRunStates()
+	if err := m.selectLastApplied(opts, unregistered); err == nil {
+		t.Error("expected -steps to refuse when a newer unregistered version has been applied")
+	}
+}
+
+type lockingDriver struct {
+	*memoryDriver
+	mu       sync.Mutex
+	calls    []string
+	renewErr error
+}
+
+func (d *lockingDriver) record(call string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls = append(d.calls, call)
+	return nil
+}
+
+func (d *lockingDriver) Lock(timeout time.Duration) error { return d.record("lock") }
+func (d *lockingDriver) RenewLock() error {
+	d.record("renew")
+	return d.renewErr
+}
+func (d *lockingDriver) Unlock() error      { return d.record("unlock") }
+func (d *lockingDriver) ForceUnlock() error { return d.record("force") }
+
+func TestLock(t *testing.T) {
+	driver := &lockingDriver{memoryDriver: newMemoryDriver()}
+	m := NewMigrator()
+	m.DbDriver = driver
+	m.SetLockRenewInterval(time.Millisecond)
+
+	if _, err := m.lock(context.Background(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	m.release()
+	m.release()
+
+	if len(driver.calls) < 3 || driver.calls[0] != "lock" || driver.calls[1] != "renew" || driver.calls[len(driver.calls)-1] != "unlock" {
+		t.Errorf("Expected the lock to be taken, renewed and released once, but the calls were %v", driver.calls)
+	}
+	for _, call := range driver.calls[1 : len(driver.calls)-1] {
+		if call != "renew" {
+			t.Errorf("Expected only renewals while the lock was held, but the calls were %v", driver.calls)
+		}
+	}
+}
+
+func TestUpStopsWhenTheLockIsLost(t *testing.T) {
+	driver := &lockingDriver{memoryDriver: newMemoryDriver(), renewErr: errors.New("lease expired")}
+	m := NewMigrator()
+	m.DbDriver = driver
+	m.SetLockRenewInterval(time.Millisecond)
+	m.Register(NewMigration(2017102500001, "add_users").Up(func(m *Migrator) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}))
+	m.Register(NewMigration(2017102500002, "add_posts").Up(func(m *Migrator) error {
+		t.Error("Expected no step to run after the lock was lost")
+		return nil
+	}))
+
+	_, err := m.Up(context.Background(), RunOptions{PreDeployOnly: true})
+	driverErr, ok := err.(*DriverError)
+	if !ok || driverErr.Op != "renew the migration lock" || driverErr.Err.Error() != "lease expired" {
+		t.Fatalf("Expected a *DriverError for the lost lock, but got %#v", err)
+	}
+	if versions := driver.versions["pre"]; len(versions) != 1 {
+		t.Errorf("Expected only the first migration to be applied, but the recorded versions were %v", versions)
+	}
+}
+
+func TestUpReportsStepErrors(t *testing.T) {
+	calls := []string{}
+	m := newTestMigrator(&calls)
+	m.Register(NewMigration(2017102500001, "add_users").PostUp(func(m *Migrator) error {
+		return errors.New("boom")
+	}))
+
+	report, err := m.Up(context.Background(), RunOptions{})
+	stepErr, ok := err.(*StepError)
+	if !ok {
+		t.Fatalf("Expected a *StepError, but got %#v", err)
+	}
+	if stepErr.Version != 2017102500001 || stepErr.Scope != "post" || stepErr.Direction != "up" || stepErr.Err.Error() != "boom" {
+		t.Errorf("Expected the error to describe the failed post-up step, but it was %#v", stepErr)
+	}
+
+	expected := []StepReport{
+		{Version: 2017102500001, Name: "add_users", Scope: "pre", Direction: "up"},
+		{Version: 2017102500001, Name: "add_users", Scope: "post", Direction: "up", Err: stepErr.Err},
+		{Version: 2017102500001, Name: "add_users", Scope: "pre", Direction: "down", Rollback: true},
+	}
+	for i := range report.Steps {
+		report.Steps[i].Duration = 0
+	}
+	if !reflect.DeepEqual(report.Steps, expected) {
+		t.Errorf("Expected the report steps to be %+v, but they were %+v", expected, report.Steps)
+	}
+	// the failed post-up step is rolled back by its hook, so only the committed pre-up step is run down.
+	if expected := []string{"begin", "commit", "begin", "rollback", "begin", "commit"}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls %v, but they were %v", expected, calls)
+	}
+	if versions := m.DbDriver.(*memoryDriver).versions; len(versions["pre"])+len(versions["post"]) != 0 {
+		t.Errorf("Expected the failed migration to be rolled back, but the recorded versions were %v", versions)
+	}
+}
+
+func TestDownRequiresVersion(t *testing.T) {
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+
+	_, err := m.Down(context.Background(), RunOptions{})
+	if _, ok := err.(*OptionsError); !ok {
+		t.Errorf("Expected an *OptionsError, but got %#v", err)
+	}
+	if exitCode(err) != 6 {
+		t.Errorf("Expected exit code 6, but it was %d", exitCode(err))
+	}
+}
+
+func TestUpStopsWhenCanceled(t *testing.T) {
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	ctx, cancel := context.WithCancel(context.Background())
+	m.Register(NewMigration(2017102500001, "add_users").UpContext(func(ctx context.Context, m *Migrator) error {
+		cancel()
+		return nil
+	}))
+
+	report, err := m.Up(ctx, RunOptions{})
+	if _, ok := err.(*CanceledError); !ok {
+		t.Fatalf("Expected a *CanceledError, but got %#v", err)
+	}
+	if len(report.Steps) != 1 || report.Steps[0].Scope != "pre" || report.Steps[0].Err != nil {
+		t.Errorf("Expected only the pre-deploy step to have completed, but the report was %+v", report.Steps)
+	}
+	if _, ok := m.DbDriver.(*memoryDriver).versions["pre"][2017102500001]; !ok {
+		t.Error("expected the completed pre-deploy step to stay recorded")
+	}
+
+	calls := []string{}
+	m = newTestMigrator(&calls)
+	m.SetTransactionScope(TransactionPerBatch)
+	ctx, cancel = context.WithCancel(context.Background())
+	m.Register(NewMigration(2017102500001, "add_users").UpContext(func(ctx context.Context, m *Migrator) error {
+		cancel()
+		return nil
+	}))
+	if _, err := m.Up(ctx, RunOptions{}); !errors.As(err, new(*CanceledError)) {
+		t.Fatalf("Expected a *CanceledError, but got %#v", err)
+	}
+	if expected := []string{"begin", "commit"}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected the batch to be committed with the completed step, but calls were %v", calls)
+	}
+}
+
+func TestStepTimeout(t *testing.T) {
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	m.Register(NewMigration(2017102500001, "slow_backfill").PostUpTimeout(10 * time.Millisecond).PostUp(func(m *Migrator) error {
+		time.Sleep(time.Second)
+		return nil
+	}))
+
+	_, err := m.Up(context.Background(), RunOptions{})
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Timeout != 10*time.Millisecond {
+		t.Fatalf("Expected the post-up step to time out after 10ms, but got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("expected the timeout to match context.DeadlineExceeded")
+	}
+	if versions := m.DbDriver.(*memoryDriver).versions; len(versions["pre"])+len(versions["post"]) != 0 {
+		t.Errorf("Expected the timed out migration to be rolled back, but the recorded versions were %v", versions)
+	}
+}
+
+func TestStepTimeoutWaitsForTheStep(t *testing.T) {
+	var stopped int32
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	m.Register(NewMigration(2017102500001, "slow_backfill").PostUpTimeout(10 * time.Millisecond).PostUpContext(func(ctx context.Context, m *Migrator) error {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		atomic.StoreInt32(&stopped, 1)
+		return ctx.Err()
+	}).PostDown(func(m *Migrator) error {
+		if atomic.LoadInt32(&stopped) == 0 {
+			t.Error("Expected the timed out step to return before it was rolled back")
+		}
+		return nil
+	}))
+
+	_, err := m.Up(context.Background(), RunOptions{})
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected the post-up step to time out, but got %v", err)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	buf := &bytes.Buffer{}
+	m.SetOutput(OutputJSON, buf)
+	m.Register(NewMigration(2017102500001, "add_users").Up(func(m *Migrator) error {
+		return nil
+	}).PostUp(func(m *Migrator) error {
+		return errors.New("boom")
+	}))
+
+	report, err := m.Up(context.Background(), RunOptions{PreDeployOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.output().finishRun(report, err, time.Second)
+
+	events := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		e := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("Expected every line to be JSON, but got %q", line)
+		}
+		events = append(events, e["event"].(string))
+		if e["event"] == "step_finish" && (e["version"].(float64) != 2017102500001 || e["scope"] != "pre" || e["direction"] != "up") {
+			t.Errorf("Expected step_finish to describe the pre-up step, but it was %s", line)
+		}
+		if e["event"] == "summary" && (e["status"] != "succeeded" || e["steps"].(float64) != 1) {
+			t.Errorf("Expected a successful summary of one step, but it was %s", line)
+		}
+	}
+	expected := []string{"step_start", "output", "step_finish", "summary"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, but they were %v", expected, events)
+	}
+
+	buf.Reset()
+	report, err = m.Up(context.Background(), RunOptions{PostDeployOnly: true})
+	if err == nil {
+		t.Fatal("Expected the post-up step to fail")
+	}
+	m.output().finishRun(report, err, time.Second)
+	events = []string{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		e := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("Expected every line to be JSON, but got %q", line)
+		}
+		events = append(events, e["event"].(string))
+		if e["event"] == "step_failure" && (e["scope"] != "post" || e["direction"] != "up" || e["error"] != "boom") {
+			t.Errorf("Expected step_failure to describe the failed post-up step, but it was %s", line)
+		}
+		if e["event"] == "summary" && e["status"] != "failed" {
+			t.Errorf("Expected a failed summary, but it was %s", line)
+		}
+	}
+	// the failed step, then its rollback.
+	expected = []string{"step_start", "output", "output", "step_failure", "step_start", "step_finish", "summary"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, but they were %v", expected, events)
+	}
+}
+
+type historyDriver struct {
+	*memoryDriver
+	entries []HistoryEntry
+}
+
+func (d *historyDriver) RecordHistory(entry HistoryEntry) error {
+	d.entries = append(d.entries, entry)
+	return nil
+}
+
+func (d *historyDriver) GetHistory() ([]HistoryEntry, error) {
+	return d.entries, nil
+}
+
+func TestHistory(t *testing.T) {
+	driver := &historyDriver{memoryDriver: newMemoryDriver()}
+	m := NewMigrator()
+	m.DbDriver = driver
+	m.SetOutput(OutputText, &bytes.Buffer{})
+	m.Register(NewMigration(2017102500001, "add_users").PostUp(func(m *Migrator) error {
+		return errors.New("boom")
+	}))
+
+	m.Up(context.Background(), RunOptions{})
+	entries, err := m.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []string{}
+	for _, entry := range entries {
+		step := entry.Scope + "-" + entry.Direction
+		if entry.Rollback {
+			step += " rollback"
+		}
+		if entry.Error != "" {
+			step += " " + entry.Error
+		}
+		steps = append(steps, step)
+		if entry.StartedAt.IsZero() || entry.FinishedAt.Before(entry.StartedAt) || entry.Host == "" {
+			t.Errorf("Expected the entry to record when and where it ran, but it was %+v", entry)
+		}
+	}
+	expected := []string{"pre-up", "post-up boom", "post-down rollback", "pre-down rollback"}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("Expected history %v, but it was %v", expected, steps)
+	}
+}
+
+type checksumDriver struct {
+	*memoryDriver
+	checksums map[int64]string
+}
+
+func (d *checksumDriver) InsertChecksum(version int64, checksum string) error {
+	d.checksums[version] = checksum
+	return nil
+}
+
+func (d *checksumDriver) GetChecksums() (map[int64]string, error) {
+	return d.checksums, nil
+}
+
+func TestChecksums(t *testing.T) {
+	defer SetChecksums(map[int64]string{})
+	SetChecksums(map[int64]string{2017102500001: "abc"})
+
+	driver := &checksumDriver{memoryDriver: newMemoryDriver(), checksums: map[int64]string{}}
+	newTest := func() *Migrator {
+		m := NewMigrator()
+		m.DbDriver = driver
+		m.SetOutput(OutputText, &bytes.Buffer{})
+		m.SetChecksumPolicy(PolicyError)
+		m.Register(NewMigration(2017102500001, "add_users").Up(func(m *Migrator) error { return nil }))
+		return m
+	}
+
+	if _, err := newTest().Up(context.Background(), RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if driver.checksums[2017102500001] != "abc" {
+		t.Fatalf("Expected the checksum to be recorded, but it was %v", driver.checksums)
+	}
+
+	SetChecksums(map[int64]string{2017102500001: "def"})
+	var checksumErr *ChecksumError
+	if _, err := newTest().Up(context.Background(), RunOptions{}); !errors.As(err, &checksumErr) {
+		t.Errorf("Expected a ChecksumError, but got %v", err)
+	}
+	if err := newTest().printStatus(&bytes.Buffer{}); !errors.As(err, &checksumErr) {
+		t.Errorf("Expected status to fail with a ChecksumError, but got %v", err)
+	}
+
+	if _, err := newTest().Up(context.Background(), RunOptions{Version: "2017102500001", Force: true}); err != nil {
+		t.Errorf("Expected forcing the modified migration to accept it, but got %v", err)
+	}
+	if driver.checksums[2017102500001] != "def" {
+		t.Errorf("Expected the checksum to be updated, but it was %v", driver.checksums)
+	}
+}
+
+func TestMigrationFileVersion(t *testing.T) {
+	if version, ok := migrationFileVersion("2017_10_25_00001_add_users.go"); !ok || version != 2017102500001 {
+		t.Errorf("Expected version 2017102500001, but got %d", version)
+	}
+	for _, name := range []string{"migrator.go", "2017_10_25_00001_add_users_test.go", "2017_10_25_00001_add_users.sql"} {
+		if _, ok := migrationFileVersion(name); ok {
+			t.Errorf("Expected %s not to be a migration file", name)
+		}
+	}
+}
+
+func TestOutOfOrder(t *testing.T) {
+	driver := newMemoryDriver()
+	driver.InsertVersion("pre", 2017102500002)
+	driver.InsertVersion("post", 2017102500002)
+	newTest := func(policy Policy, allow bool) *Migrator {
+		m := NewMigrator()
+		m.DbDriver = driver
+		m.SetOutput(OutputText, &bytes.Buffer{})
+		m.SetOutOfOrderPolicy(policy)
+		hotfix := NewMigration(2017102500001, "hotfix")
+		if allow {
+			hotfix.AllowOutOfOrder()
+		}
+		m.Register(hotfix)
+		m.Register(NewMigration(2017102500002, "add_users"))
+		return m
+	}
+
+	var outOfOrderErr *OutOfOrderError
+	if _, err := newTest(PolicyError, false).Up(context.Background(), RunOptions{DryRun: true}); !errors.As(err, &outOfOrderErr) {
+		t.Errorf("Expected an OutOfOrderError, but got %v", err)
+	}
+	if _, err := newTest(PolicyError, true).Up(context.Background(), RunOptions{DryRun: true}); err != nil {
+		t.Errorf("Expected AllowOutOfOrder to let the migration run, but got %v", err)
+	}
+	if _, err := newTest(PolicyWarn, false).Up(context.Background(), RunOptions{}); err != nil {
+		t.Errorf("Expected only a warning, but got %v", err)
+	}
+}
+
+func TestUnknownVersions(t *testing.T) {
+	driver := newMemoryDriver()
+	driver.InsertVersion("pre", 2017102500009)
+	m := NewMigrator()
+	m.DbDriver = driver
+	m.SetOutput(OutputText, &bytes.Buffer{})
+	m.SetUnknownVersionsPolicy(PolicyError)
+	m.Register(NewMigration(2017102500001, "add_users"))
+
+	var unknownErr *UnknownVersionsError
+	if _, err := m.Up(context.Background(), RunOptions{}); !errors.As(err, &unknownErr) || len(unknownErr.Versions) != 1 {
+		t.Errorf("Expected an UnknownVersionsError, but got %v", err)
+	}
+	if err := m.printStatus(&bytes.Buffer{}); !errors.As(err, &unknownErr) {
+		t.Errorf("Expected status to fail with an UnknownVersionsError, but got %v", err)
+	}
+}
+
+func TestVerifyApplied(t *testing.T) {
+	driver := newMemoryDriver()
+	for _, version := range []int64{2017102500001, 2017102500002} {
+		driver.InsertVersion("pre", version)
+		driver.InsertVersion("post", version)
+	}
+	driver.InsertVersion("pre", 2017102500003)
+	m := NewMigrator()
+	m.DbDriver = driver
+	m.SetOutput(OutputText, &bytes.Buffer{})
+	verified := []string{}
+	verify := func(name string, err error) migrationStepFunc {
+		return func(m *Migrator) error {
+			verified = append(verified, name)
+			return err
+		}
+	}
+	m.Register(NewMigration(2017102500001, "add_users").Verify(verify("add_users", errors.New("missing index"))))
+	m.Register(NewMigration(2017102500002, "add_posts").Verify(verify("add_posts", nil)))
+	m.Register(NewMigration(2017102500003, "pre_only").Verify(verify("pre_only", errors.New("post-deploy backfill not run"))))
+	m.Register(NewMigration(2017102500004, "pending").Verify(verify("pending", nil)))
+
+	report, err := m.VerifyApplied(context.Background(), "")
+	var verifyErr *VerifyError
+	if !errors.As(err, &verifyErr) || len(verifyErr.Failures) != 1 || verifyErr.Failures[0].Version != 2017102500001 {
+		t.Fatalf("Expected the add_users verification to fail, but got %v", err)
+	}
+	if expected := []string{"add_users", "add_posts"}; !reflect.DeepEqual(verified, expected) {
+		t.Errorf("Expected verifications %v, but ran %v", expected, verified)
+	}
+	if len(report.Steps) != 2 || exitCode(err) != 4 {
+		t.Errorf("Expected 2 reported steps and exit code 4, but got %d and %d", len(report.Steps), exitCode(err))
+	}
+	if pre, _ := driver.GetAllRunVersions("pre"); len(pre) != 3 {
+		t.Errorf("Expected verify not to change the database, but pre versions are %v", pre)
+	}
+
+	verified = nil
+	if _, err := m.VerifyApplied(context.Background(), "2017_10_25_00002"); err != nil || len(verified) != 1 {
+		t.Errorf("Expected only add_posts to be verified, but got %v and ran %v", err, verified)
+	}
+}
+
+func TestDependsOn(t *testing.T) {
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	m.Register(NewMigration(2017102500001, "add_users"))
+	m.Register(NewMigration(2017102500002, "add_posts").DependsOn(2017102500003))
+	m.Register(NewMigration(2017102500003, "add_tags"))
+	m.Register(NewMigration(2017102500004, "add_comments"))
+
+	if _, err := m.Up(context.Background(), RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	names := func() []string {
+		result := []string{}
+		for _, mig := range m.Migrations {
+			result = append(result, mig.Name)
+		}
+		return result
+	}
+	if expected := []string{"add_users", "add_tags", "add_posts", "add_comments"}; !reflect.DeepEqual(names(), expected) {
+		t.Errorf("Expected order %v, but it was %v", expected, names())
+	}
+
+	report, err := m.Down(context.Background(), RunOptions{Steps: 3, PreDeployOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := []string{}
+	for _, step := range report.Steps {
+		down = append(down, step.Name)
+	}
+	if expected := []string{"add_comments", "add_posts", "add_tags"}; !reflect.DeepEqual(down, expected) {
+		t.Errorf("Expected to run down %v, but ran %v", expected, down)
+	}
+}
+
+func TestDependencyErrors(t *testing.T) {
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	m.Register(NewMigration(2017102500001, "add_users").DependsOn(2017102500009))
+	var dependencyErr *DependencyError
+	if _, err := m.Up(context.Background(), RunOptions{}); !errors.As(err, &dependencyErr) {
+		t.Errorf("Expected a DependencyError for a missing dependency, but got %v", err)
+	}
+
+	duplicates := NewMigrator()
+	duplicates.DbDriver = newMemoryDriver()
+	duplicates.Migrations = SortableMigrations{NewMigration(2017102500001, "add_users"), NewMigration(2017102500001, "add_posts")}
+	if _, err := duplicates.Up(context.Background(), RunOptions{}); !errors.As(err, &dependencyErr) || !strings.Contains(err.Error(), "2017_10_25_00001 (add_users) and 2017_10_25_00001 (add_posts) have the same version") {
+		t.Errorf("Expected a DependencyError naming the duplicate version, but got %v", err)
+	}
+
+	m.Register(NewMigration(2017102500009, "add_posts").DependsOn(2017102500001))
+	if _, err := m.Up(context.Background(), RunOptions{}); !errors.As(err, &dependencyErr) || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("Expected a DependencyError for a dependency cycle, but got %v", err)
+	}
+}
+
+func TestParallel(t *testing.T) {
+	driver := newMemoryDriver()
+	m := NewMigrator()
+	m.DbDriver = driver
+	m.SetOutput(OutputText, &bytes.Buffer{})
+
+	started := sync.WaitGroup{}
+	started.Add(3)
+	step := func(err error) migrationStepFunc {
+		return func(m *Migrator) error {
+			started.Done()
+			started.Wait()
+			return err
+		}
+	}
+	m.Register(NewMigration(2017102500001, "backfill_users").PostUp(step(nil)).Parallelizable())
+	m.Register(NewMigration(2017102500002, "backfill_posts").PostUp(step(errors.New("boom"))).Parallelizable())
+	m.Register(NewMigration(2017102500003, "backfill_tags").PostUp(step(nil)).Parallelizable())
+	m.Register(NewMigration(2017102500004, "add_index"))
+
+	_, err := m.Up(context.Background(), RunOptions{PostDeployOnly: true, Parallel: 3})
+	var parallelErr *ParallelError
+	if !errors.As(err, &parallelErr) || len(parallelErr.Failures) != 1 || parallelErr.Failures[0].Version != 2017102500002 {
+		t.Fatalf("Expected backfill_posts to fail, but got %v", err)
+	}
+	post, _ := driver.GetAllRunVersions(string(scopePostMigration))
+	sort.Slice(post, func(a, b int) bool { return post[a] < post[b] })
+	if expected := []int64{2017102500001, 2017102500003}; !reflect.DeepEqual(post, expected) {
+		t.Errorf("Expected post-deploy versions %v, but they were %v", expected, post)
+	}
+
+	var optionsErr *OptionsError
+	if _, err := m.Up(context.Background(), RunOptions{Parallel: 2}); !errors.As(err, &optionsErr) {
+		t.Errorf("Expected -parallel to be rejected without -post, but got %v", err)
+	}
+	m.SetTransactionScope(TransactionPerBatch)
+	if _, err := m.Up(context.Background(), RunOptions{PostDeployOnly: true, Parallel: 2}); !errors.As(err, &optionsErr) {
+		t.Errorf("Expected -parallel to be rejected in a batch transaction scope, but got %v", err)
+	}
+
+	calls := []string{}
+	m = newTestMigrator(&calls)
+	m.Register(NewMigration(2017102500001, "backfill_users").PostUp(step(nil)).Parallelizable())
+	if _, err := m.Up(context.Background(), RunOptions{PostDeployOnly: true, Parallel: 2}); !errors.As(err, &optionsErr) || len(calls) != 0 {
+		t.Errorf("Expected -parallel to be rejected with transaction hooks, but got %v", err)
+	}
+}
+
+type classifyingDriver struct {
+	*memoryDriver
+}
+
+func (d *classifyingDriver) IsRetryable(err error) bool {
+	return err.Error() == "lock timeout"
+}
+
+func TestRetry(t *testing.T) {
+	calls := []string{}
+	m := newTestMigrator(&calls)
+	m.SetOutput(OutputText, &bytes.Buffer{})
+	failures := 2
+	m.Register(NewMigration(2017102500001, "add_users").Up(func(m *Migrator) error {
+		if failures > 0 {
+			failures--
+			return Retryable(errors.New("serialization failure"))
+		}
+		return nil
+	}).Retry(3, time.Millisecond))
+
+	if _, err := m.Up(context.Background(), RunOptions{PreDeployOnly: true}); err != nil {
+		t.Fatalf("Expected the step to succeed on its third attempt, but got %v", err)
+	}
+	expected := []string{"begin", "rollback", "begin", "rollback", "begin", "commit"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls %v, but they were %v", expected, calls)
+	}
+
+	attempts := 0
+	m = NewMigrator()
+	m.DbDriver = &classifyingDriver{memoryDriver: newMemoryDriver()}
+	m.SetOutput(OutputText, &bytes.Buffer{})
+	m.SetRetry(2, time.Millisecond)
+	m.Register(NewMigration(2017102500001, "add_users").Up(func(m *Migrator) error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("lock timeout")
+		}
+		return errors.New("syntax error")
+	}))
+	if _, err := m.Up(context.Background(), RunOptions{PreDeployOnly: true}); err == nil || attempts != 2 {
+		t.Errorf("Expected the driver's retryable error to be retried once, but got %v after %d attempts", err, attempts)
+	}
+
+	m.SetTransactionScope(TransactionPerBatch)
+	if _, err := m.Up(context.Background(), RunOptions{}); !errors.As(err, new(*OptionsError)) {
+		t.Errorf("Expected retries with TransactionPerBatch to be refused, but got %v", err)
+	}
+}
+
+func TestIrreversible(t *testing.T) {
+	driver := newMemoryDriver()
+	m := NewMigrator()
+	m.DbDriver = driver
+	m.SetOutput(OutputText, &bytes.Buffer{})
+	noop := func(m *Migrator) error { return nil }
+	m.Register(NewMigration(2017102500001, "add_users").Up(noop))
+	m.Register(NewMigration(2017102500002, "drop_legacy").Up(noop).Irreversible("drops the legacy table"))
+	if _, err := m.Up(context.Background(), RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var irreversibleErr *IrreversibleError
+	if _, err := m.Down(context.Background(), RunOptions{Steps: 1}); !errors.As(err, &irreversibleErr) || !strings.Contains(err.Error(), "drops the legacy table") {
+		t.Errorf("Expected an IrreversibleError, but got %v", err)
+	}
+	if _, err := m.Redo(context.Background(), RunOptions{}); !errors.As(err, &irreversibleErr) {
+		t.Errorf("Expected redo to refuse an irreversible migration, but got %v", err)
+	}
+	if _, err := m.Down(context.Background(), RunOptions{Version: "2017102500002", Force: true}); err != nil {
+		t.Errorf("Expected -force to run the migration down, but got %v", err)
+	}
+
+	m.SetMissingDownPolicy(PolicyError)
+	if _, err := m.Down(context.Background(), RunOptions{Version: "2017102500001"}); !errors.As(err, &irreversibleErr) {
+		t.Errorf("Expected a missing down function to fail, but got %v", err)
+	}
+	if pre, _ := driver.GetAllRunVersions(string(scopePreMigration)); len(pre) != 1 {
+		t.Errorf("Expected add_users to still be applied, but pre versions are %v", pre)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	logs := &bytes.Buffer{}
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	m.SetLogger(slog.New(slog.NewJSONHandler(logs, nil)))
+	m.Register(NewMigration(2017102500001, "add_users").Up(func(m *Migrator) error {
+		m.Logger().Info("Creating table")
+		return nil
+	}))
+
+	if _, err := m.Up(context.Background(), RunOptions{PreDeployOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	records := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		record := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Expected a JSON log record, but got %q", line)
+		}
+		records = append(records, record)
+	}
+
+	messages := []string{}
+	for _, record := range records {
+		messages = append(messages, record["msg"].(string))
+		if record["version"] != float64(2017102500001) || record["scope"] != "pre" || record["direction"] != "up" {
+			t.Errorf("Expected %q to carry the step's attributes, but it was %v", record["msg"], record)
+		}
+	}
+	expected := []string{"Step started", "Running pre-up migration (add_users)", "Creating table", "Step finished"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("Expected log messages %v, but they were %v", expected, messages)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	defer func(interval time.Duration) { progressInterval = interval }(progressInterval)
+	progressInterval = time.Hour
+
+	out := &bytes.Buffer{}
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	m.SetOutput(OutputJSON, out)
+	m.Register(NewMigration(2017102500001, "backfill_users").PostUp(func(m *Migrator) error {
+		for done := int64(0); done <= 100; done += 10 {
+			m.Progress(done, 100)
+		}
+		return nil
+	}))
+	m.Progress(1, 2)
+
+	report, err := m.Up(context.Background(), RunOptions{PostDeployOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := report.Steps[0].Progress; p == nil || p.Done != 100 || p.Total != 100 {
+		t.Errorf("Expected the report to include the last progress, but it was %+v", p)
+	}
+
+	done := []int64{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		e := event{}
+		json.Unmarshal([]byte(line), &e)
+		if e.Event == "progress" {
+			done = append(done, *e.Done)
+		}
+	}
+	if expected := []int64{0, 100}; !reflect.DeepEqual(done, expected) {
+		t.Errorf("Expected progress to be reported at the start and the end, but it was reported at %v", done)
+	}
+
+	if s := formatProgress(25, 100, 5, 15*time.Second); s != "Progress: 25/100 (25.0%), 5.0/s, ETA 15s" {
+		t.Errorf("Unexpected progress line %q", s)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	out := &bytes.Buffer{}
+	m := NewMigrator()
+	m.DbDriver = newMemoryDriver()
+	m.SetOutput(OutputText, out)
+
+	rows := 25
+	cursors := []int64{}
+	pauses := 1
+	b := Backfill{
+		BatchSize: 10,
+		Batch: func(ctx context.Context, cursor int64, size int) (int64, int, error) {
+			cursors = append(cursors, cursor)
+			processed := rows - int(cursor)
+			if processed > size {
+				processed = size
+			}
+			return cursor + int64(processed), processed, nil
+		},
+		ShouldPause: func(ctx context.Context) (bool, error) {
+			pauses--
+			return pauses >= 0, nil
+		},
+		PauseInterval: time.Millisecond,
+	}
+	m.Register(NewMigration(2017102500001, "backfill_users").PostUpContext(func(ctx context.Context, m *Migrator) error {
+		return m.Backfill(ctx, b)
+	}))
+
+	if _, err := m.Up(context.Background(), RunOptions{PostDeployOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int64{0, 10, 20, 25}; !reflect.DeepEqual(cursors, expected) {
+		t.Errorf("Expected batches after cursors %v, but they were after %v", expected, cursors)
+	}
+	for _, line := range []string{"Backfill paused at cursor 0", "Backfilled 10 row(s) up to cursor 10", "Backfill done: 25 row(s), stopped at cursor 25"} {
+		if !strings.Contains(out.String(), "[2017_10_25_00001] "+line) {
+			t.Errorf("Expected the output to contain %q, but it was:\n%s", line, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "up to cursor 20") {
+		t.Errorf("Expected the cursor to be reported at most every progress interval, but the output was:\n%s", out.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.ShouldPause = nil
+	b.Batch = func(ctx context.Context, cursor int64, size int) (int64, int, error) {
+		cancel()
+		return cursor + 10, 10, nil
+	}
+	if err := m.Backfill(ctx, b); !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "cursor 10") {
+		t.Errorf("Expected the backfill to stop at cursor 10 when canceled, but got %v", err)
+	}
+
+	b.Batch = func(ctx context.Context, cursor int64, size int) (int64, int, error) {
+		return cursor, size, nil
+	}
+	if err := m.Backfill(context.Background(), b); err == nil || !strings.Contains(err.Error(), "doesn't advance") {
+		t.Errorf("Expected a batch that doesn't advance the cursor to fail, but got %v", err)
+	}
+
+	driver := &checkpointDriver{memoryDriver: newMemoryDriver(), checkpoints: map[string]string{}}
+	m = NewMigrator()
+	m.DbDriver = driver
+	m.SetOutput(OutputText, &bytes.Buffer{})
+	failed := false
+	b.Total = int64(rows)
+	b.Batch = func(ctx context.Context, cursor int64, size int) (int64, int, error) {
+		if cursor == 20 && !failed {
+			failed = true
+			return 0, 0, errors.New("connection reset")
+		}
+		processed := rows - int(cursor)
+		if processed > size {
+			processed = size
+		}
+		return cursor + int64(processed), processed, nil
+	}
+	m.Register(NewMigration(2017102500001, "backfill_users").PostUpContext(func(ctx context.Context, m *Migrator) error {
+		return m.Backfill(ctx, b)
+	}))
+	if _, err := m.Up(context.Background(), RunOptions{PostDeployOnly: true}); err == nil {
+		t.Fatal("Expected the first backfill to fail")
+	}
+	report, err := m.Up(context.Background(), RunOptions{PostDeployOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress := report.Steps[0].Progress; progress == nil || *progress != (Progress{Done: 25, Total: 25}) {
+		t.Errorf("Expected the resumed backfill to count the rows done before it failed, but the progress was %+v", progress)
+	}
+}
+
+type checkpointDriver struct {
+	*memoryDriver
+	checkpoints map[string]string
+}
+
+func (d *checkpointDriver) SaveCheckpoint(version int64, step, key, value string) error {
+	d.checkpoints[fmt.Sprintf("%d %s %s", version, step, key)] = value
+	return nil
+}
+
+func (d *checkpointDriver) LoadCheckpoint(version int64, step, key string) (string, bool, error) {
+	value, ok := d.checkpoints[fmt.Sprintf("%d %s %s", version, step, key)]
+	return value, ok, nil
+}
+
+func (d *checkpointDriver) ClearCheckpoints(version int64, step string) error {
+	for key := range d.checkpoints {
+		if strings.HasPrefix(key, fmt.Sprintf("%d %s ", version, step)) {
+			delete(d.checkpoints, key)
+		}
+	}
+	return nil
+}
+
+func TestCheckpoints(t *testing.T) {
+	driver := &checkpointDriver{memoryDriver: newMemoryDriver(), checkpoints: map[string]string{}}
+	m := NewMigrator()
+	m.DbDriver = driver
+	m.SetOutput(OutputText, &bytes.Buffer{})
+
+	calls := []string{}
+	fail := true
+	m.Register(NewMigration(2017102500001, "backfill_users").Up(func(m *Migrator) error {
+		return nil
+	}).PostUp(func(m *Migrator) error {
+		if err := m.SubStep("copy", func(m *Migrator) error {
+			calls = append(calls, "copy")
+			return nil
+		}); err != nil {
+			return err
+		}
+		return m.SubStep("index", func(m *Migrator) error {
+			checkpoint, _, err := m.LoadCheckpoint()
+			if err != nil {
+				return err
+			}
+			calls = append(calls, "index from "+checkpoint)
+			if err := m.SaveCheckpoint("half"); err != nil {
+				return err
+			}
+			if fail {
+				fail = false
+				return errors.New("boom")
+			}
+			return nil
+		})
+	}))
+
+	if _, err := m.Up(context.Background(), RunOptions{}); err == nil {
+		t.Fatal("Expected the first run to fail")
+	}
+	if pre, _ := driver.GetAllRunVersions(string(scopePreMigration)); len(pre) != 1 {
+		t.Errorf("Expected the checkpointed migration not to be rolled back, but pre versions are %v", pre)
+	}
+	if _, err := m.Up(context.Background(), RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"copy", "index from ", "index from half"}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls %v, but they were %v", expected, calls)
+	}
+	if len(driver.checkpoints) != 0 {
+		t.Errorf("Expected the checkpoints to be cleared once the step completed, but they were %v", driver.checkpoints)
+	}
+
+	driver.SaveCheckpoint(2017102500002, "post-up", "checkpoint", "stale")
+	m.Register(NewMigration(2017102500002, "abandoned").Up(func(m *Migrator) error { return nil }))
+	driver.InsertVersion(string(scopePreMigration), 2017102500002)
+	if _, err := m.Down(context.Background(), RunOptions{Version: "2017102500002"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(driver.checkpoints) != 0 {
+		t.Errorf("Expected running down to clear the up checkpoints, but they were %v", driver.checkpoints)
+	}
+
+	var optionsErr *OptionsError
+	if _, err := m.Up(context.Background(), RunOptions{ResetCheckpoints: true}); !errors.As(err, &optionsErr) {
+		t.Errorf("Expected -reset-checkpoints without -force to be rejected, but got %v", err)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	driver := newMemoryDriver()
+	m := NewMigrator()
+	m.DbDriver = driver
+	m.SetOutput(OutputText, &bytes.Buffer{})
+	ran := false
+	step := func(m *Migrator) error {
+		ran = true
+		return nil
+	}
+	m.Register(NewMigration(2017102500001, "add_users").Up(step).PostUp(step))
+	m.Register(NewMigration(2017102500002, "add_posts").Up(step))
+	m.Register(NewMigration(2017102500003, "add_tags").Up(step))
+
+	var optionsErr *OptionsError
+	if _, err := m.Baseline(context.Background(), RunOptions{}); !errors.As(err, &optionsErr) {
+		t.Errorf("Expected baseline without a version to be rejected, but got %v", err)
+	}
+	report, err := m.Baseline(context.Background(), RunOptions{Version: "2017_10_25_00002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ran || len(report.Steps) != 4 {
+		t.Errorf("Expected 4 versions recorded without running anything, but got %d steps and ran=%v", len(report.Steps), ran)
+	}
+	for _, scope := range []string{"pre", "post"} {
+		versions, _ := driver.GetAllRunVersions(scope)
+		sort.Slice(versions, func(a, b int) bool { return versions[a] < versions[b] })
+		if expected := []int64{2017102500001, 2017102500002}; !reflect.DeepEqual(versions, expected) {
+			t.Errorf("Expected %s versions %v, but they were %v", scope, expected, versions)
+		}
+	}
+
+	if _, err := m.Baseline(context.Background(), RunOptions{Version: "2017102500003"}); !errors.As(err, &optionsErr) {
+		t.Errorf("Expected baseline over recorded versions to be refused, but got %v", err)
+	}
+	report, err = m.Baseline(context.Background(), RunOptions{Version: "2017102500003", Force: true})
+	if err != nil || len(report.Steps) != 2 || ran {
+		t.Errorf("Expected -force to record only add_tags, but got %v and %d steps", err, len(report.Steps))
+	}
+}
+
+func TestZeroPolicies(t *testing.T) {
+	m := &Migrator{DbDriver: newMemoryDriver()}
+	m.SetOutput(OutputText, &bytes.Buffer{})
+	m.Register(NewMigration(2017102500001, "add_users"))
+	for _, p := range []Policy{m.checksumPolicy, m.outOfOrderPolicy, m.unknownVersionsPolicy, m.missingDownPolicy} {
+		if !p.valid() {
+			t.Errorf("Expected the zero policy to be valid")
+		}
+	}
+	if _, err := m.Up(context.Background(), RunOptions{}); err != nil {
+		t.Errorf("Expected a Migrator built without NewMigrator to run, but got %v", err)
+	}
+}
+
+func TestRedoIsOneTransaction(t *testing.T) {
+	calls := []string{}
+	m := newTestMigrator(&calls)
+	m.SetOutput(OutputText, &bytes.Buffer{})
+	step := func(name string, err error) migrationStepFunc {
+		return func(m *Migrator) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	m.Register(NewMigration(2017102500001, "add_users").Up(step("up", errors.New("boom"))).Down(step("down", nil)))
+	m.DbDriver.InsertVersion(string(scopePreMigration), 2017102500001)
+	m.DbDriver.InsertVersion(string(scopePostMigration), 2017102500001)
+
+	if _, err := m.Redo(context.Background(), RunOptions{}); err == nil {
+		t.Fatal("Expected the redo to fail")
+	}
+	if expected := []string{"begin", "down", "up", "rollback"}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected the down and up to share one transaction, but calls were %v", calls)
+	}
+	if m.transactionScope != TransactionPerMigration {
+		t.Error("Expected redo to restore the transaction scope")
+	}
+
+	driver := newMemoryDriver()
+	m = NewMigrator()
+	m.DbDriver = driver
+	m.SetOutput(OutputText, &bytes.Buffer{})
+	calls = []string{}
+	m.Register(NewMigration(2017102500001, "add_users").Up(step("up", nil)).Down(step("down", nil)).
+		PostUp(step("post-up", errors.New("boom"))).PostDown(step("post-down", nil)))
+	driver.InsertVersion(string(scopePreMigration), 2017102500001)
+	driver.InsertVersion(string(scopePostMigration), 2017102500001)
+
+	if _, err := m.Redo(context.Background(), RunOptions{}); err == nil {
+		t.Fatal("Expected the redo to fail")
+	}
+	if expected := []string{"post-down", "down", "up", "post-up", "post-down", "down"}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected the failed up to be rolled back without hooks, but calls were %v", calls)
+	}
+	if versions := driver.versions; len(versions["pre"])+len(versions["post"]) != 0 {
+		t.Errorf("Expected the migration to be left run down, but the recorded versions were %v", versions)
+	}
+}
@@ -1,16 +1,68 @@
 package migrator
 
+import "time"
+
 type InstallOptions struct {
 	Help *bool
 }
 
 type UpDownOptions struct {
-	PreDeployOnly  *bool
-	PostDeployOnly *bool
-	Version        *string
-	Force          *bool
-	Production     *bool
-	Help           *bool
+	PreDeployOnly    *bool
+	PostDeployOnly   *bool
+	Version          *string
+	ToVersion        *string
+	Steps            *int
+	Force            *bool
+	DryRun           *bool
+	Parallel         *int
+	ResetCheckpoints *bool
+
+	ChecksumPolicy        *string
+	OutOfOrderPolicy      *string
+	UnknownVersionsPolicy *string
+	MissingDownPolicy     *string
+	RetryAttempts         *int
+	RetryBackoff          *time.Duration
+	LockTimeout           *time.Duration
+	Output                *string
+	Production            *bool
+	Help                  *bool
+}
+
+type StatusOptions struct {
+	ChecksumPolicy        *string
+	UnknownVersionsPolicy *string
+	Production            *bool
+	Help                  *bool
+}
+
+type UnlockOptions struct {
+	Production *bool
+	Help       *bool
+}
+
+type HistoryOptions struct {
+	Version    *string
+	Limit      *int
+	Production *bool
+	Help       *bool
+}
+
+type VerifyOptions struct {
+	Version    *string
+	Output     *string
+	Production *bool
+	Help       *bool
+}
+
+type BaselineOptions struct {
+	Version     *string
+	Force       *bool
+	DryRun      *bool
+	LockTimeout *time.Duration
+	Output      *string
+	Production  *bool
+	Help        *bool
 }
 
 type NewOptions struct {
@@ -19,12 +71,40 @@ type NewOptions struct {
 }
 
 type Options struct {
-	Install InstallOptions
-	Build   BuildOptions
-	New     NewOptions
-	Up      UpDownOptions
-	Down    UpDownOptions
+	Install  InstallOptions
+	Build    BuildOptions
+	New      NewOptions
+	Up       UpDownOptions
+	Down     UpDownOptions
+	Redo     UpDownOptions
+	Status   StatusOptions
+	Unlock   UnlockOptions
+	History  HistoryOptions
+	Verify   VerifyOptions
+	Baseline BaselineOptions
 }
 
 type BuildOptions struct {
 }
+
+// RunOptions select which migrations a call to Up, Down or Redo runs. They mirror the flags of the migrator binary.
+type RunOptions struct {
+	PreDeployOnly  bool
+	PostDeployOnly bool
+	// Version runs only this version, given as registered (2017102500001) or as formatted (2017_10_25_00001).
+	Version string
+	// ToVersion runs up to and including this version, or down to (but not including) it.
+	ToVersion string
+	// Steps runs down the last N applied migrations.
+	Steps int
+	// Force runs Version even if it has already run, or already run down.
+	Force  bool
+	DryRun bool
+	// Parallel runs the post-deploy steps of up to this many migrations marked Parallelizable at the same time. It
+	// needs PostDeployOnly and a Migrator without transaction hooks.
+	Parallel int
+	// ResetCheckpoints, with Force, starts the steps over instead of resuming them from their checkpoints.
+	ResetCheckpoints bool
+	// LockTimeout is how long to wait for the migration lock, when the DbDriver is a Locker. Defaults to a minute.
+	LockTimeout time.Duration
+}
@@ -0,0 +1,81 @@
+package migrator
+
+// SetOutOfOrderPolicy sets what happens when a pending migration is older than the newest applied one, which is
+// common after merging a long-lived branch. The default is PolicyWarn. Migrations marked with AllowOutOfOrder are
+// always allowed.
+func (m *Migrator) SetOutOfOrderPolicy(p Policy) {
+	m.outOfOrderPolicy = p
+}
+
+// SetUnknownVersionsPolicy sets what happens when versions have been applied to the database but aren't registered
+// with the Migrator. The default is PolicyWarn.
+func (m *Migrator) SetUnknownVersionsPolicy(p Policy) {
+	m.unknownVersionsPolicy = p
+}
+
+// checkUnknownVersions applies the unknown versions policy to the applied versions setRunStates couldn't match to a
+// registered migration.
+func (m *Migrator) checkUnknownVersions(unregistered []*Migration) error {
+	if len(unregistered) == 0 || m.unknownVersionsPolicy == PolicyAllow {
+		return nil
+	}
+	versions := []string{}
+	for _, mig := range unregistered {
+		versions = append(versions, mig.FormattedNumber)
+	}
+	if m.unknownVersionsPolicy == PolicyError {
+		return &UnknownVersionsError{Versions: versions}
+	}
+	for _, version := range versions {
+		m.output().warn("version " + version + " has been applied but is not registered in this migrator")
+	}
+	return nil
+}
+
+// checkOutOfOrder applies the out-of-order policy to the planned steps, finding migrations about to run for the
+// first time that are ordered before an applied migration, or are older than an applied unregistered version.
+func (m *Migrator) checkOutOfOrder(steps []migrationStep, unregistered []*Migration) error {
+	if m.outOfOrderPolicy == PolicyAllow {
+		return nil
+	}
+	position := map[*Migration]int{}
+	var newest *Migration
+	for i, mig := range m.Migrations {
+		position[mig] = i
+		if mig.preHasRun || mig.postHasRun {
+			newest = mig
+		}
+	}
+	var newestUnregistered *Migration
+	for _, mig := range unregistered {
+		if mig.preHasRun || mig.postHasRun {
+			newestUnregistered = mig
+		}
+	}
+
+	migrations := []string{}
+	seen := map[int64]bool{}
+	for _, step := range steps {
+		mig := step.mig
+		if step.direction != directionUp || mig.preHasRun || mig.postHasRun || mig.allowOutOfOrder || seen[mig.OrderingNumber] {
+			continue
+		}
+		if newest != nil && position[mig] < position[newest] {
+			seen[mig.OrderingNumber] = true
+			migrations = append(migrations, mig.FormattedNumber+" ("+mig.Name+") is ordered before the applied migration "+newest.FormattedNumber)
+		} else if newestUnregistered != nil && mig.OrderingNumber < newestUnregistered.OrderingNumber {
+			seen[mig.OrderingNumber] = true
+			migrations = append(migrations, mig.FormattedNumber+" ("+mig.Name+") is older than the applied version "+newestUnregistered.FormattedNumber)
+		}
+	}
+	if len(migrations) == 0 {
+		return nil
+	}
+	if m.outOfOrderPolicy == PolicyError {
+		return &OutOfOrderError{Migrations: migrations}
+	}
+	for _, mig := range migrations {
+		m.output().warn("migration " + mig)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package migrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// OutputFormat is the format the migrator reports on a run in.
+type OutputFormat string
+
+const (
+	// OutputText prints lines meant for people. This is the default.
+	OutputText OutputFormat = "text"
+	// OutputJSON prints a JSON object per line for every step as it starts and finishes or fails, for every line of
+	// migration output, and a summary of the run at the end.
+	OutputJSON OutputFormat = "json"
+)
+
+// SetOutput sets the format runs are reported in, and where the report is written.
+func (m *Migrator) SetOutput(format OutputFormat, w io.Writer) {
+	m.out = &output{format: format, w: w}
+}
+
+type output struct {
+	format OutputFormat
+	w      io.Writer
+	mu     sync.Mutex
+
+	// logger, when set, receives everything instead of w.
+	logger *slog.Logger
+
+	terminalOnce sync.Once
+	isTerminal   bool
+	// partial is set while a progress line drawn on a terminal is waiting for its newline.
+	partial bool
+}
+
+var defaultOutput = &output{format: OutputText, w: os.Stdout}
+
+// event is a single line of JSON output.
+type event struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	Version   int64     `json:"version,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Scope     string    `json:"scope,omitempty"`
+	Direction string    `json:"direction,omitempty"`
+	Rollback  bool      `json:"rollback,omitempty"`
+	Duration  *float64  `json:"duration_seconds,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Error     string    `json:"error,omitempty"`
+
+	// progress and step_finish
+	Done  *int64   `json:"done,omitempty"`
+	Total *int64   `json:"total,omitempty"`
+	Rate  *float64 `json:"rate,omitempty"`
+	ETA   *float64 `json:"eta_seconds,omitempty"`
+
+	// summary only
+	Status string `json:"status,omitempty"`
+	Steps  *int   `json:"steps,omitempty"`
+	Failed *int   `json:"failed,omitempty"`
+}
+
+func (m *Migrator) output() *output {
+	if m.out == nil {
+		return defaultOutput
+	}
+	return m.out
+}
+
+func (o *output) json() bool {
+	return o.format == OutputJSON
+}
+
+func (o *output) println(s string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.endLineLocked()
+	fmt.Fprintln(o.w, s)
+}
+
+func (o *output) emit(e event) {
+	e.Time = time.Now().UTC()
+	b, err := json.Marshal(e)
+	if err != nil {
+		panic("Could not serialize output event: " + err.Error())
+	}
+	o.println(string(b))
+}
+
+func (o *output) migrationOutput(mig *Migration, s string) {
+	if o.logger != nil {
+		o.logger.Info(s, stepAttrs(mig, mig.runningScope, mig.runningDirection)...)
+		return
+	}
+	if o.json() {
+		o.emit(event{Event: "output", Version: mig.OrderingNumber, Name: mig.Name, Message: s})
+		return
+	}
+	o.println("[" + mig.FormattedNumber + "] " + s)
+}
+
+func (o *output) message(s string) {
+	if o.logger != nil {
+		o.logger.Info(s)
+		return
+	}
+	if o.json() {
+		o.emit(event{Event: "message", Message: s})
+		return
+	}
+	o.println(s)
+}
+
+func (o *output) warn(s string) {
+	if o.logger != nil {
+		o.logger.Warn(s)
+		return
+	}
+	if o.json() {
+		o.emit(event{Event: "warning", Message: s})
+		return
+	}
+	o.println("WARNING: " + s)
+}
+
+// startStep reports that a step is starting. In text output the step announces itself through Migration.Output.
+func (m *Migrator) startStep(ctx context.Context, mig *Migration, scope, direction string) {
+	mig.runningScope, mig.runningDirection = scope, direction
+	mig.progress = &stepProgress{mig: mig, scope: scope, direction: direction, start: time.Now()}
+	if o := m.output(); o.logger != nil {
+		o.logStep(mig, scope, direction, isRollback(ctx), false, 0, nil)
+	} else if o.json() {
+		o.emit(event{Event: "step_start", Version: mig.OrderingNumber, Name: mig.Name, Scope: scope, Direction: direction, Rollback: isRollback(ctx)})
+	}
+}
+
+// finishStep records a step that ran in the report of the run in progress, and reports it.
+func (m *Migrator) finishStep(ctx context.Context, mig *Migration, scope, direction string, start time.Time, err error) {
+	step := StepReport{
+		Version:   mig.OrderingNumber,
+		Name:      mig.Name,
+		Scope:     scope,
+		Direction: direction,
+		Rollback:  isRollback(ctx),
+		Duration:  time.Since(start),
+		Err:       err,
+	}
+	if p := mig.progress; p != nil {
+		p.mu.Lock()
+		step.Progress = p.current
+		p.mu.Unlock()
+	}
+	if m.report != nil {
+		m.report.mu.Lock()
+		m.report.add(step)
+		m.report.mu.Unlock()
+	}
+	m.recordHistory(step, start)
+	mig.runningScope, mig.runningDirection = "", ""
+
+	o := m.output()
+	if o.terminal() {
+		o.endLine()
+	}
+	if o.logger != nil {
+		o.logStep(mig, scope, direction, step.Rollback, true, step.Duration, err)
+	} else if o.json() {
+		e := event{Event: "step_finish", Version: step.Version, Name: step.Name, Scope: scope, Direction: direction, Rollback: step.Rollback}
+		seconds := step.Duration.Seconds()
+		e.Duration = &seconds
+		if step.Progress != nil {
+			e.Done, e.Total = &step.Progress.Done, &step.Progress.Total
+		}
+		if err != nil {
+			e.Event = "step_failure"
+			e.Error = err.Error()
+		}
+		o.emit(e)
+	}
+}
+
+// finishRun reports the outcome of a run started by Run.
+func (o *output) finishRun(report *Report, err error, duration time.Duration) {
+	if o.logger != nil {
+		o.logRun(report, err, duration)
+		return
+	}
+	if !o.json() {
+		if report != nil && report.DryRun {
+			printPlan(o.w, report.Steps)
+		}
+		if err != nil {
+			if _, ok := err.(*CanceledError); ok && report != nil {
+				printCompleted(o.w, report.Steps)
+			}
+			o.println(err.Error())
+		}
+		return
+	}
+
+	steps, failed := 0, 0
+	if report != nil {
+		for _, step := range report.Steps {
+			if report.DryRun {
+				o.emit(event{Event: "planned_step", Version: step.Version, Name: step.Name, Scope: step.Scope, Direction: step.Direction})
+			}
+		}
+		steps, failed = len(report.Steps), len(report.Failed())
+	}
+	seconds := duration.Seconds()
+	e := event{Event: "summary", Status: "succeeded", Steps: &steps, Failed: &failed, Duration: &seconds}
+	if report != nil && report.DryRun {
+		e.Status = "dry_run"
+	}
+	if err != nil {
+		e.Status = "failed"
+		if _, ok := err.(*CanceledError); ok {
+			e.Status = "canceled"
+		}
+		e.Error = err.Error()
+	}
+	o.emit(e)
+}
+
+type rollbackKey struct{}
+
+// withRollback marks the steps run with ctx as undoing a failed step.
+func withRollback(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rollbackKey{}, true)
+}
+
+func isRollback(ctx context.Context) bool {
+	rollback, _ := ctx.Value(rollbackKey{}).(bool)
+	return rollback
+}
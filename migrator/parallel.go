@@ -0,0 +1,107 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ParallelError is returned when post-deploy steps running in parallel fail. Each failed migration has been rolled
+// back on its own, the others have completed, and nothing after them was started.
+type ParallelError struct {
+	Failures []*StepError
+}
+
+func (e *ParallelError) Error() string {
+	failures := []string{}
+	for _, failure := range e.Failures {
+		failures = append(failures, failure.Error())
+	}
+	return fmt.Sprintf("%d parallel migration(s) failed: %s", len(e.Failures), strings.Join(failures, "; "))
+}
+
+// Parallelizable lets the migration's post-deploy step run at the same time as those of other Parallelizable
+// migrations when the migrator runs up with -post and -parallel, which can't be used with transaction hooks. Its step
+// functions and the DbDriver must then be safe to use concurrently.
+func (m *Migration) Parallelizable() *Migration {
+	m.parallelizable = true
+	return m
+}
+
+// parallelUnits groups the leading steps that can run concurrently: the post-deploy up steps, and the verifications
+// that follow them, of Parallelizable migrations that don't depend on each other. Each unit holds the steps of one
+// migration, to run in order. It also returns how many steps the units hold.
+func parallelUnits(steps []migrationStep) ([][]migrationStep, int) {
+	units := [][]migrationStep{}
+	unitOf := map[int64]int{}
+	grouped := map[int64]bool{}
+	n := 0
+	for _, step := range steps {
+		mig := step.mig
+		if !mig.parallelizable || !(step.verify || (step.scope == scopePostMigration && step.direction == directionUp)) {
+			break
+		}
+		if i, ok := unitOf[mig.OrderingNumber]; ok {
+			units[i] = append(units[i], step)
+			n++
+			continue
+		}
+		if waitsOnPending(mig, grouped) {
+			break
+		}
+		unitOf[mig.OrderingNumber] = len(units)
+		grouped[mig.OrderingNumber] = true
+		units = append(units, []migrationStep{step})
+		n++
+	}
+	return units, n
+}
+
+// executeParallel runs the units on up to opts.parallel workers. A failed unit is rolled back without stopping the
+// others, and all of the failures are returned together in a *ParallelError.
+func (m *Migrator) executeParallel(ctx context.Context, units [][]migrationStep, opts *runOptions) error {
+	work := make(chan []migrationStep)
+	failures := []*StepError{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < opts.parallel && w < len(units); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for unit := range work {
+				for _, step := range unit {
+					if err := m.runPlannedStep(ctx, step); err != nil {
+						stepErr := m.fail(ctx, step, opts, err)
+						mu.Lock()
+						failures = append(failures, stepErr)
+						mu.Unlock()
+						break
+					}
+				}
+			}
+		}()
+	}
+
+	started := 0
+	for _, unit := range units {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- unit
+		started++
+	}
+	close(work)
+	wg.Wait()
+
+	if len(failures) > 0 {
+		sort.Slice(failures, func(a, b int) bool { return failures[a].Version < failures[b].Version })
+		return &ParallelError{Failures: failures}
+	}
+	if started < len(units) {
+		m.output().message(fmt.Sprintf("Canceled, %d migration(s) were not started", len(units)-started))
+		return stopError(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// runOptions are the parsed and validated options for a single run of the migrator.
+type runOptions struct {
+	runPre  bool
+	runPost bool
+	down    bool
+	redo    bool
+	version string
+	to      string
+	toIndex int
+	steps   int
+	force   bool
+	dryRun  bool
+
+	parallel         int
+	resetCheckpoints bool
+	lockTimeout      time.Duration
+
+	// lastApplied holds the versions picked by -steps.
+	lastApplied map[int64]struct{}
+}
+
+// runOptions validates the options and converts them for a run in the given direction.
+func (o RunOptions) runOptions(down, redo bool) (*runOptions, error) {
+	opts := &runOptions{
+		runPre:           !o.PostDeployOnly,
+		runPost:          !o.PreDeployOnly,
+		down:             down,
+		redo:             redo,
+		version:          normalizeVersion(o.Version),
+		to:               normalizeVersion(o.ToVersion),
+		steps:            o.Steps,
+		force:            o.Force,
+		dryRun:           o.DryRun,
+		parallel:         o.Parallel,
+		resetCheckpoints: o.ResetCheckpoints,
+		lockTimeout:      o.LockTimeout,
+	}
+
+	if !opts.runPre && !opts.runPost {
+		return nil, &OptionsError{Message: "Cannot use both -pre and -post exclusivity flags at the same time. If you want to run both (which is the default), don't supply -pre and -post arguments"}
+	}
+	if (opts.version != "" && opts.to != "") || (opts.steps > 0 && (opts.version != "" || opts.to != "")) {
+		return nil, &OptionsError{Message: "Only one of -version, -to and -steps can be used at a time"}
+	}
+	if opts.redo && (opts.to != "" || opts.steps > 0) {
+		return nil, &OptionsError{Message: "Cannot use -to or -steps with redo, use -version or leave it out to redo the latest applied migration"}
+	}
+	if opts.steps < 0 || (opts.steps > 0 && !opts.down) {
+		return nil, &OptionsError{Message: "-steps must be a positive number, and can only be used when running down"}
+	}
+	if opts.parallel < 0 || (opts.parallel > 1 && (opts.down || opts.redo || opts.runPre)) {
+		return nil, &OptionsError{Message: "-parallel must be a positive number, and can only be used when running up with -post"}
+	}
+	if opts.resetCheckpoints && !opts.force {
+		return nil, &OptionsError{Message: "-reset-checkpoints can only be used with -force"}
+	}
+	if opts.force && opts.version == "" && !opts.redo {
+		return nil, &OptionsError{Message: "Cannot use -force without -version", exitCode: 3}
+	}
+	if opts.down && opts.version == "" && opts.to == "" && opts.steps == 0 {
+		return nil, &OptionsError{Message: "Cannot run down migrations without a version specified, use -version, -to or -steps", exitCode: 6}
+	}
+	if opts.lockTimeout <= 0 {
+		opts.lockTimeout = time.Minute
+	}
+	return opts, nil
+}
+
+// migrationStep is a single unit of work in a run: one scope of one migration in one direction, or the verification
+// of a migration once its up steps have run.
+type migrationStep struct {
+	mig       *Migration
+	scope     scope
+	direction direction
+	verify    bool
+}
+
+// plan works out which steps a run will execute and in which order, based on the run states set by setRunStates.
+func (m *Migrator) plan(opts *runOptions) []migrationStep {
+	steps := []migrationStep{}
+
+	if !opts.down {
+		for i, mig := range m.Migrations {
+			if !opts.selects(i, mig) {
+				continue
+			}
+			ran := false
+			if opts.runPre && (!mig.preHasRun || opts.force) {
+				steps = append(steps, migrationStep{mig: mig, scope: scopePreMigration, direction: directionUp})
+				ran = true
+			}
+			if opts.runPost && (!mig.postHasRun || opts.force) {
+				steps = append(steps, migrationStep{mig: mig, scope: scopePostMigration, direction: directionUp})
+				ran = true
+			}
+			// verify once both scopes are applied, so that a -pre run doesn't verify work its -post run will do.
+			applied := (opts.runPre || mig.preHasRun) && (opts.runPost || mig.postHasRun)
+			if ran && applied && mig.verifyFunc != nil {
+				steps = append(steps, migrationStep{mig: mig, verify: true})
+			}
+		}
+		return steps
+	}
+
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		mig := m.Migrations[i]
+		if !opts.selects(i, mig) {
+			continue
+		}
+		if opts.runPost && (mig.postHasRun || opts.force) {
+			steps = append(steps, migrationStep{mig: mig, scope: scopePostMigration, direction: directionDown})
+		}
+		if opts.runPre && (mig.preHasRun || opts.force) {
+			steps = append(steps, migrationStep{mig: mig, scope: scopePreMigration, direction: directionDown})
+		}
+	}
+	return steps
+}
+
+// selects reports whether the migration at position i of the sorted migrations is part of the run. Going up, -to
+// includes its own version; going down, it is the version to stop at, so it is left applied.
+func (o *runOptions) selects(i int, mig *Migration) bool {
+	if o.version != "" && strconv.FormatInt(mig.OrderingNumber, 10) != o.version {
+		return false
+	}
+	if o.steps > 0 {
+		_, ok := o.lastApplied[mig.OrderingNumber]
+		return ok
+	}
+	if o.to != "" {
+		if o.down {
+			return i > o.toIndex
+		}
+		return i <= o.toIndex
+	}
+	return true
+}
+
+// selectLastApplied picks the opts.steps most recently applied migrations for -steps, considering only the scopes
+// being run. It refuses when a version that has run but is not registered is newer than any of them, since it can't
+// be rolled back from this binary.
+func (m *Migrator) selectLastApplied(opts *runOptions, unregistered []*Migration) error {
+	opts.lastApplied = map[int64]struct{}{}
+	oldest := int64(0)
+	for i := len(m.Migrations) - 1; i >= 0 && len(opts.lastApplied) < opts.steps; i-- {
+		mig := m.Migrations[i]
+		if (opts.runPre && mig.preHasRun) || (opts.runPost && mig.postHasRun) {
+			opts.lastApplied[mig.OrderingNumber] = struct{}{}
+			oldest = mig.OrderingNumber
+		}
+	}
+	if len(opts.lastApplied) < opts.steps {
+		return &OptionsError{Message: fmt.Sprintf("Cannot run down %d migrations, only %d have been applied", opts.steps, len(opts.lastApplied))}
+	}
+
+	for _, mig := range unregistered {
+		if mig.OrderingNumber > oldest && ((opts.runPre && mig.preHasRun) || (opts.runPost && mig.postHasRun)) {
+			return &OptionsError{Message: fmt.Sprintf("Cannot run down the last %d migrations, version %s has been applied but is not registered in this migrator", opts.steps, mig.FormattedNumber)}
+		}
+	}
+	return nil
+}
+
+// rollBack undoes a migration after one of its up steps, or its verification, failed. The failed step is undone by
+// its own down step, unless the PostFailure hook has already rolled back its transaction, and a failed post-deploy
+// step or verification also undoes the pre-deploy step. Failed down steps are not undone. The rollback runs even if
+// ctx has been canceled, since that is often why the step failed.
+func (m *Migrator) rollBack(ctx context.Context, failed migrationStep, opts *runOptions) {
+	mig := failed.mig
+	if failed.direction == directionDown {
+		return
+	}
+	ctx = withRollback(context.WithoutCancel(ctx))
+	undoFailed := m.postFailure == nil
+
+	switch {
+	case failed.verify:
+		if opts.runPost && mig.postHasRun {
+			m.runFunctionHook(ctx, mig, mig.postDownFunc, directionDown, scopePostMigration, mig.OrderingNumber)
+		}
+		if opts.runPre && mig.preHasRun {
+			m.runFunctionHook(ctx, mig, mig.downFunc, directionDown, scopePreMigration, mig.OrderingNumber)
+		}
+	case failed.scope == scopePreMigration:
+		if undoFailed {
+			m.runFunctionHook(ctx, mig, mig.downFunc, directionDown, scopePreMigration, mig.OrderingNumber)
+		}
+	default:
+		if undoFailed {
+			m.runFunctionHook(ctx, mig, mig.postDownFunc, directionDown, scopePostMigration, mig.OrderingNumber)
+		}
+		if opts.runPre {
+			m.runFunctionHook(ctx, mig, mig.downFunc, directionDown, scopePreMigration, mig.OrderingNumber)
+		}
+	}
+}
+
+// printPlan prints the steps a run would execute, for -dry-run.
+func printPlan(w io.Writer, steps []StepReport) {
+	if len(steps) == 0 {
+		fmt.Fprintln(w, "Dry run: there are no migrations to run")
+		return
+	}
+
+	fmt.Fprintln(w, "Dry run: the following steps would run, in this order")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tVERSION\tNAME\tSCOPE\tDIRECTION")
+	for i, step := range steps {
+		scope := step.Scope
+		if scope == "" {
+			scope = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", i+1, formatVersion(step.Version), step.Name, scope, step.Direction)
+	}
+	tw.Flush()
+}
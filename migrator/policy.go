@@ -0,0 +1,18 @@
+package migrator
+
+// Policy is what the migrator does when it finds something suspicious about the migrations, such as a migration
+// that was edited after it was applied. The empty Policy is the same as PolicyWarn.
+type Policy string
+
+const (
+	// PolicyError refuses to run.
+	PolicyError Policy = "error"
+	// PolicyWarn prints a warning and carries on.
+	PolicyWarn Policy = "warn"
+	// PolicyAllow carries on silently.
+	PolicyAllow Policy = "allow"
+)
+
+func (p Policy) valid() bool {
+	return p == "" || p == PolicyError || p == PolicyWarn || p == PolicyAllow
+}
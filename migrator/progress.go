@@ -0,0 +1,145 @@
+package migrator
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Progress is how far a step has got, as reported by the step through Migrator.Progress.
+type Progress struct {
+	Done  int64
+	Total int64
+}
+
+// progressInterval is how often progress is reported when it can't be redrawn in place on a terminal.
+var progressInterval = 10 * time.Second
+
+const terminalProgressInterval = 200 * time.Millisecond
+
+// stepProgress tracks the progress of the step running.
+type stepProgress struct {
+	mig       *Migration
+	scope     string
+	direction string
+	start     time.Time
+
+	mu       sync.Mutex
+	current  *Progress
+	reported time.Time
+	// resumed is the work done by earlier attempts of the step, left out of the rate.
+	resumed int64
+	// checkpointed is set once the step saves a checkpoint.
+	checkpointed bool
+}
+
+// name is the scope and direction of the step, such as "post-up".
+func (p *stepProgress) name() string {
+	if p.scope == "" {
+		return p.direction
+	}
+	return p.scope + "-" + p.direction
+}
+
+// resume records that earlier attempts of the step had already done this much work.
+func (p *stepProgress) resume(done int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resumed = done
+}
+
+// Progress reports how far a long-running step has got, as done out of total units of work, or total 0 if it isn't
+// known. On a terminal it draws a line with the rate and estimated time left that updates in place; otherwise it is
+// reported every 10 seconds. The last progress reported is included in the step's report. It does nothing when not
+// called from a step.
+func (m *Migrator) Progress(done, total int64) {
+	p := m.progress
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current = &Progress{Done: done, Total: total}
+
+	o := m.output()
+	interval := progressInterval
+	if o.terminal() {
+		interval = terminalProgressInterval
+	}
+	now := time.Now()
+	if now.Sub(p.reported) < interval && (total <= 0 || done < total) {
+		return
+	}
+	p.reported = now
+
+	rate := float64(done-p.resumed) / now.Sub(p.start).Seconds()
+	eta := time.Duration(-1)
+	if total > 0 && rate > 0 {
+		eta = time.Duration(float64(total-done) / rate * float64(time.Second)).Round(time.Second)
+	}
+	o.progress(p, done, total, rate, eta)
+}
+
+func (o *output) progress(p *stepProgress, done, total int64, rate float64, eta time.Duration) {
+	switch {
+	case o.logger != nil:
+		attrs := append(stepAttrs(p.mig, p.scope, p.direction), slog.Int64("done", done), slog.Int64("total", total), slog.Float64("rate", rate))
+		if eta >= 0 {
+			attrs = append(attrs, slog.Duration("eta", eta))
+		}
+		o.logger.Info("Progress", attrs...)
+	case o.json():
+		e := event{Event: "progress", Version: p.mig.OrderingNumber, Name: p.mig.Name, Scope: p.scope, Direction: p.direction, Done: &done, Total: &total, Rate: &rate}
+		if eta >= 0 {
+			seconds := eta.Seconds()
+			e.ETA = &seconds
+		}
+		o.emit(e)
+	case o.terminal():
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		fmt.Fprintf(o.w, "\r\033[K[%s] %s", p.mig.FormattedNumber, formatProgress(done, total, rate, eta))
+		o.partial = true
+	default:
+		o.migrationOutput(p.mig, formatProgress(done, total, rate, eta))
+	}
+}
+
+func formatProgress(done, total int64, rate float64, eta time.Duration) string {
+	if total <= 0 {
+		return fmt.Sprintf("Progress: %d, %.1f/s", done, rate)
+	}
+	s := fmt.Sprintf("Progress: %d/%d (%.1f%%), %.1f/s", done, total, float64(done)/float64(total)*100, rate)
+	if eta >= 0 {
+		s += ", ETA " + eta.String()
+	}
+	return s
+}
+
+// terminal reports whether text output goes to a terminal, where progress can be redrawn in place.
+func (o *output) terminal() bool {
+	o.terminalOnce.Do(func() {
+		if f, ok := o.w.(*os.File); ok && !o.json() && o.logger == nil {
+			if info, err := f.Stat(); err == nil {
+				o.isTerminal = info.Mode()&os.ModeCharDevice != 0
+			}
+		}
+	})
+	return o.isTerminal
+}
+
+// endLine finishes a progress line drawn on a terminal, so that whatever comes next starts on its own line.
+func (o *output) endLine() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.endLineLocked()
+}
+
+func (o *output) endLineLocked() {
+	if o.partial {
+		fmt.Fprintln(o.w)
+		o.partial = false
+	}
+}
@@ -0,0 +1,84 @@
+package migrator
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Report describes the steps a run executed, or for a dry run the steps it would execute.
+type Report struct {
+	DryRun bool
+	Steps  []StepReport
+}
+
+// StepReport describes a single step of a run.
+type StepReport struct {
+	Version   int64
+	Name      string
+	Scope     string // "pre" or "post", empty for a verification
+	Direction string // "up", "down", "verify" or "baseline"
+	Rollback  bool   // the step ran to undo a failed step
+	Duration  time.Duration
+	Err       error
+	// Progress is the last progress the step reported, if it reported any.
+	Progress *Progress
+}
+
+// Failed returns the steps that failed.
+func (r *Report) Failed() []StepReport {
+	failed := []StepReport{}
+	for _, step := range r.Steps {
+		if step.Err != nil {
+			failed = append(failed, step)
+		}
+	}
+	return failed
+}
+
+// add records a step that ran. It does nothing outside of a run.
+func (r *Report) add(step StepReport) {
+	if r == nil {
+		return
+	}
+	r.Steps = append(r.Steps, step)
+}
+
+// runReport is the report of the run in progress, guarded while steps run in parallel.
+type runReport struct {
+	mu sync.Mutex
+	*Report
+}
+
+// addPlan records the steps a dry run would execute.
+func (r *Report) addPlan(steps []migrationStep) {
+	for _, step := range steps {
+		s := StepReport{
+			Version:   step.mig.OrderingNumber,
+			Name:      step.mig.Name,
+			Scope:     string(step.scope),
+			Direction: string(step.direction),
+		}
+		if step.verify {
+			s.Direction = "verify"
+		}
+		r.Steps = append(r.Steps, s)
+	}
+}
+
+// printCompleted prints the steps that completed, for a run that was interrupted.
+func printCompleted(w io.Writer, steps []StepReport) {
+	fmt.Fprintf(w, "Completed %d step(s) before stopping:\n", len(steps))
+	for _, step := range steps {
+		result := "ok"
+		if step.Err != nil {
+			result = "failed"
+		}
+		name := step.Direction
+		if step.Scope != "" {
+			name = step.Scope + "-" + step.Direction
+		}
+		fmt.Fprintf(w, "  [%s] %s %s: %s\n", formatVersion(step.Version), step.Name, name, result)
+	}
+}
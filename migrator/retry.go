@@ -0,0 +1,76 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetryClassifier is an optional interface a DbDriver can implement to mark errors, such as lock timeouts or
+// serialization failures, as transient so that steps failing with them are retried.
+type RetryClassifier interface {
+	IsRetryable(err error) bool
+}
+
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// Retryable marks an error returned by a step as transient, so the step is retried if it has attempts left.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+// Retry runs a step of the migration up to attempts times when it fails with a retryable error, waiting backoff
+// before the first retry and doubling the wait before each one after. It overrides the Migrator's default.
+func (m *Migration) Retry(attempts int, backoff time.Duration) *Migration {
+	m.retryAttempts = attempts
+	m.retryBackoff = backoff
+	return m
+}
+
+// SetRetry sets how many times steps of migrations without their own Retry are attempted when they fail with a
+// retryable error, and how long to wait before the first retry. By default steps are not retried. Retries need the
+// transaction hooks to wrap each step, so a run with TransactionPerBatch returns an *OptionsError.
+func (m *Migrator) SetRetry(attempts int, backoff time.Duration) {
+	m.retryAttempts = attempts
+	m.retryBackoff = backoff
+}
+
+// retryPolicy returns how many times a step of the migration may be attempted, and the wait before the first retry.
+func (m *Migrator) retryPolicy(mig *Migration) (int, time.Duration) {
+	if mig.retryAttempts > 0 {
+		return mig.retryAttempts, mig.retryBackoff
+	}
+	return m.retryAttempts, m.retryBackoff
+}
+
+// isRetryable reports whether err was marked with Retryable, or is transient according to the DbDriver.
+func (m *Migrator) isRetryable(err error) bool {
+	var retryable *retryableError
+	if errors.As(err, &retryable) {
+		return true
+	}
+	if classifier, ok := m.DbDriver.(RetryClassifier); ok {
+		return classifier.IsRetryable(err)
+	}
+	return false
+}
+
+// waitToRetry announces a retry and waits for backoff, returning false if ctx is canceled first.
+func (m *Migrator) waitToRetry(ctx context.Context, mig *Migration, step string, attempt, attempts int, backoff time.Duration, err error) bool {
+	mig.Output(fmt.Sprintf("Retrying %s migration in %s (attempt %d of %d) after: %v", step, backoff, attempt+1, attempts, err))
+	return sleepContext(ctx, backoff) == nil
+}
@@ -0,0 +1,117 @@
+package migrator
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// MigrationStatus is the state of a single migration in the database.
+type MigrationStatus struct {
+	Version         int64
+	FormattedNumber string
+	Name            string
+	PreApplied      bool
+	PostApplied     bool
+	// Registered is false for versions that have run against the database but aren't registered with the Migrator.
+	Registered bool
+	// Modified is true for applied migrations whose source has changed since they were applied.
+	Modified bool
+}
+
+// Status returns the state of every registered migration, followed by any versions that have run against the
+// database but are not registered.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	if err := m.sortMigrations(); err != nil {
+		return nil, err
+	}
+	unregistered, err := m.setRunStates()
+	if err != nil {
+		return nil, err
+	}
+
+	modified, err := m.modifiedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	isModified := map[int64]bool{}
+	for _, mig := range modified {
+		isModified[mig.OrderingNumber] = true
+	}
+
+	result := []MigrationStatus{}
+	for _, mig := range m.Migrations {
+		s := newMigrationStatus(mig, true)
+		s.Modified = isModified[mig.OrderingNumber]
+		result = append(result, s)
+	}
+	for _, mig := range unregistered {
+		result = append(result, newMigrationStatus(mig, false))
+	}
+	return result, nil
+}
+
+func newMigrationStatus(mig *Migration, registered bool) MigrationStatus {
+	return MigrationStatus{
+		Version:         mig.OrderingNumber,
+		FormattedNumber: mig.FormattedNumber,
+		Name:            mig.Name,
+		PreApplied:      mig.preHasRun,
+		PostApplied:     mig.postHasRun,
+		Registered:      registered,
+	}
+}
+
+// printStatus prints every registered migration with its pre and post-deploy state, followed by any versions that
+// have run against the database but are not registered in this binary.
+func (m *Migrator) printStatus(w io.Writer) error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	unregistered := 0
+	modified := []string{}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tPRE-DEPLOY\tPOST-DEPLOY")
+	for _, s := range statuses {
+		name := s.Name
+		if !s.Registered {
+			name = "(not registered)"
+			unregistered++
+		}
+		if s.Modified {
+			name += " (modified)"
+			modified = append(modified, s.FormattedNumber+" ("+s.Name+")")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.FormattedNumber, name, runState(s.PreApplied), runState(s.PostApplied))
+	}
+	tw.Flush()
+
+	if unregistered > 0 && m.unknownVersionsPolicy == PolicyError {
+		versions := []string{}
+		for _, s := range statuses {
+			if !s.Registered {
+				versions = append(versions, s.FormattedNumber)
+			}
+		}
+		return &UnknownVersionsError{Versions: versions}
+	}
+	if unregistered > 0 && m.unknownVersionsPolicy != PolicyAllow {
+		fmt.Fprintf(w, "\nWARNING: %d version(s) have run against the database but are not registered in this migrator\n", unregistered)
+	}
+	if len(modified) > 0 && m.checksumPolicy != PolicyAllow {
+		if m.checksumPolicy == PolicyError {
+			return &ChecksumError{Migrations: modified}
+		}
+		fmt.Fprintf(w, "\nWARNING: %d migration(s) have been modified since they were applied\n", len(modified))
+	}
+	return nil
+}
+
+func runState(hasRun bool) string {
+	if hasRun {
+		return "applied"
+	}
+	return "pending"
+}
@@ -1,28 +1,78 @@
 package migrator
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// cliOutput is where the migrate command prints its own progress. With -output json it is stderr, leaving stdout
+// to the JSON lines of the migrator binary.
+var cliOutput io.Writer = os.Stdout
+
 func UpMigration(options *UpDownOptions) {
-	runMigration("up", options)
+	useOutputFormat(options.Output)
+	runMigration("up", options.Production)
+	fmt.Fprintln(cliOutput, "Done migrating")
 }
 
 func DownMigration(options *UpDownOptions) {
-	runMigration("down", options)
+	useOutputFormat(options.Output)
+	runMigration("down", options.Production)
+	fmt.Fprintln(cliOutput, "Done migrating")
+}
+
+func RedoMigration(options *UpDownOptions) {
+	useOutputFormat(options.Output)
+	runMigration("redo", options.Production)
+	fmt.Fprintln(cliOutput, "Done migrating")
+}
+
+func StatusMigration(options *StatusOptions) {
+	runMigration("status", options.Production)
+}
+
+func HistoryMigration(options *HistoryOptions) {
+	runMigration("history", options.Production)
+}
+
+func VerifyMigration(options *VerifyOptions) {
+	useOutputFormat(options.Output)
+	runMigration("verify", options.Production)
+	fmt.Fprintln(cliOutput, "Done verifying")
 }
 
-func runMigration(direction string, options *UpDownOptions) {
+func BaselineMigration(options *BaselineOptions) {
+	useOutputFormat(options.Output)
+	runMigration("baseline", options.Production)
+	fmt.Fprintln(cliOutput, "Done baselining")
+}
+
+func UnlockMigration(options *UnlockOptions) {
+	runMigration("unlock", options.Production)
+}
+
+func useOutputFormat(format *string) {
+	if format != nil && *format == string(OutputJSON) {
+		cliOutput = os.Stderr
+	}
+}
+
+// runMigration builds the migrator binary if needed and runs command with it.
+func runMigration(command string, production *bool) {
 	config = LoadConfig()
-	if !migrationBinaryExists() || !*options.Production {
+	if !migrationBinaryExists() || production == nil || !*production {
 		buildMigrationBinary()
 	}
-	runMigrationBinary(direction)
-
-	fmt.Println("Done migrating")
+	runMigrationBinary(command)
 }
 
 func migrationBinaryExists() bool {
@@ -31,16 +81,17 @@ func migrationBinaryExists() bool {
 
 func buildMigrationBinary() {
 	// go build -o binary source driver
-	goArgs := []string{"build", "-o", migratorBinFile(), originMigratorGoFile()}
+	goArgs := []string{"build", "-o", migratorBinFile(), "-ldflags", buildStampFlag(), originMigratorGoFile()}
 
 	driverFile := path.Join(config.LocalMigratorPath, "driver.go")
 	if FileExists(driverFile) {
 		goArgs = append(goArgs, driverFile)
 	}
+	goArgs = append(goArgs, writeChecksumFile())
 
-	fmt.Println("go", goArgs)
+	fmt.Fprintln(cliOutput, "go", goArgs)
 	buildCmd := exec.Command("go", goArgs...)
-	buildCmd.Stdout = os.Stdout
+	buildCmd.Stdout = cliOutput
 	buildCmd.Stderr = os.Stderr
 	err := buildCmd.Run()
 	if err != nil {
@@ -48,9 +99,17 @@ func buildMigrationBinary() {
 	}
 }
 
-func runMigrationBinary(direction string) {
+// buildStampFlag returns the -ldflags that stamp the migrator binary with when and where it was built, for its
+// migration history.
+func buildStampFlag() string {
+	host, _ := os.Hostname()
+	stamp := "built " + time.Now().UTC().Format(time.RFC3339) + " on " + host
+	return "-X 'github.com/ssoroka/gomigrate/migrator.buildStamp=" + stamp + "'"
+}
+
+func runMigrationBinary(command string) {
 	// migratorBinary -config etc
-	migratorArgs := []string{"-" + direction}
+	migratorArgs := []string{"-" + command}
 
 	// pass args on to the migration binary, with a few exceptions.
 	for i := range os.Args {
@@ -58,23 +117,75 @@ func runMigrationBinary(direction string) {
 			continue
 		}
 		switch os.Args[i] {
-		case "up", "down", "--":
+		case "up", "down", "redo", "status", "history", "verify", "baseline", "unlock", "--":
 			continue
 		}
 		migratorArgs = append(migratorArgs, os.Args[i])
 	}
+	migratorArgs = append(migratorArgs, configFlags(migratorArgs)...)
 
 	bin := migratorBinFile()
-	fmt.Println(bin, migratorArgs)
+	fmt.Fprintln(cliOutput, bin, migratorArgs)
 
 	cmd := exec.Command(bin, migratorArgs...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
+	if err := cmd.Start(); err != nil {
 		panic("Couldn't run migrations: " + err.Error())
 	}
 
+	// stay alive while the migrator stops cleanly between steps. Ctrl-C already reaches it through the terminal's
+	// process group, so only SIGTERM is passed on, and a second Ctrl-C isn't doubled into an immediate kill.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		// the migrator has reported the failure, pass its exit status on.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		panic("Couldn't run migrations: " + err.Error())
+	}
+
+}
+
+// configFlags returns the migrator flags set in the config file, leaving out any given on the command line.
+func configFlags(args []string) []string {
+	given := map[string]bool{}
+	for _, arg := range args {
+		name := strings.TrimLeft(strings.SplitN(arg, "=", 2)[0], "-")
+		given[name] = true
+	}
+
+	flags := []string{}
+	if config.ChecksumPolicy != "" && !given["checksum-policy"] {
+		flags = append(flags, "-checksum-policy="+config.ChecksumPolicy)
+	}
+	if config.OutOfOrderPolicy != "" && !given["out-of-order"] {
+		flags = append(flags, "-out-of-order="+config.OutOfOrderPolicy)
+	}
+	if config.UnknownVersionsPolicy != "" && !given["unknown-versions"] {
+		flags = append(flags, "-unknown-versions="+config.UnknownVersionsPolicy)
+	}
+	if config.MissingDownPolicy != "" && !given["missing-down"] {
+		flags = append(flags, "-missing-down="+config.MissingDownPolicy)
+	}
+	if config.RetryAttempts > 0 && !given["retry-attempts"] {
+		flags = append(flags, "-retry-attempts="+strconv.Itoa(config.RetryAttempts))
+	}
+	if config.RetryBackoff != "" && !given["retry-backoff"] {
+		flags = append(flags, "-retry-backoff="+config.RetryBackoff)
+	}
+	return flags
 }
 
 func originMigratorGoFile() string {
@@ -0,0 +1,62 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// VerifyError is returned by VerifyApplied when verifications fail. Every verification still runs, so it lists all
+// of the failures.
+type VerifyError struct {
+	Failures []*StepError
+}
+
+func (e *VerifyError) Error() string {
+	failures := []string{}
+	for _, failure := range e.Failures {
+		failures = append(failures, failure.Error())
+	}
+	return fmt.Sprintf("%d verification(s) failed: %s", len(e.Failures), strings.Join(failures, "; "))
+}
+
+// VerifyApplied runs the verification of every applied migration, or only of version if it isn't empty, without
+// changing the database. Migrations without a verification, or with only one of their scopes applied, are skipped. If any verification fails a *VerifyError
+// is returned once they have all run.
+func (m *Migrator) VerifyApplied(ctx context.Context, version string) (*Report, error) {
+	if err := m.sortMigrations(); err != nil {
+		return nil, err
+	}
+	version = normalizeVersion(version)
+	if version != "" && m.indexOf(version) < 0 {
+		return nil, &OptionsError{Message: "Cannot verify version " + version + ", it is not a registered migration"}
+	}
+	if _, err := m.setRunStates(); err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	m.report = &runReport{Report: report}
+	defer func() { m.report = nil }()
+
+	passed := 0
+	failures := []*StepError{}
+	for i, mig := range m.Migrations {
+		if (version != "" && i != m.indexOf(version)) || !(mig.preHasRun && mig.postHasRun) || mig.verifyFunc == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return report, &CanceledError{Err: ctx.Err()}
+		}
+		if err := m.runVerify(ctx, mig); err != nil {
+			failures = append(failures, newStepError(migrationStep{mig: mig, verify: true}, err))
+		} else {
+			passed++
+		}
+	}
+	m.output().message(fmt.Sprintf("%d verification(s) passed, %d failed", passed, len(failures)))
+	if len(failures) > 0 {
+		return report, &VerifyError{Failures: failures}
+	}
+	return report, nil
+}